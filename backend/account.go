@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var errUnauthorized = errors.New("brak autoryzacji")
+
+// authenticate resolves the user behind the Bearer token of the request. Only
+// the token most recently issued to the user is accepted, so tokens replaced
+// on password change or cleared on logout stop working immediately.
+func authenticate(r *http.Request) (*User, string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, "", errUnauthorized
+	}
+
+	tokenString := authHeader[len("Bearer "):]
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok {
+		return nil, "", errUnauthorized
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if user.Token == "" || user.Token != tokenString {
+		return nil, "", errUnauthorized
+	}
+
+	return user, tokenString, nil
+}
+
+// requireFacilitator checks that the request comes from the facilitator of
+// the session and writes the error response otherwise.
+func requireFacilitator(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	if session.FacilitatorID == "" {
+		return true
+	}
+
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return false
+	}
+
+	if user.ID != session.FacilitatorID {
+		http.Error(w, "Tylko prowadzący sesję może to zrobić", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// anonymizedPlayerName is the name that replaces a deleted user in session
+// history. It is derived from the user ID so that votes of the same user stay
+// grouped together without revealing who cast them.
+func anonymizedPlayerName(user *User) string {
+	id := user.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return "usunięty-" + id
+}
+
+// anonymizeUserInSession replaces the user's name in the session like
+// renameUserInSession and also drops the user from the members and the
+// authors of stories. It reports whether anything was changed.
+func anonymizeUserInSession(session *Session, user *User, newName string) bool {
+	changed := renameUserInSession(session, user, newName)
+
+	for i, id := range session.Members {
+		if id == user.ID {
+			session.Members = append(session.Members[:i], session.Members[i+1:]...)
+			changed = true
+			break
+		}
+	}
+
+	for _, story := range session.allStories() {
+		if story.AuthorID == user.ID {
+			story.AuthorID = ""
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// renameUserInSession replaces the user's name in the session players list,
+// in the votes of the current and past rounds and in the stories they added.
+// It reports whether anything was changed.
+func renameUserInSession(session *Session, user *User, newName string) bool {
+	changed := false
+
+	oldName := user.Username
+
+	for i, p := range session.Players {
+		if p == oldName {
+			session.Players[i] = newName
+			changed = true
+		}
+	}
+
+	for _, round := range session.allRounds() {
+		for _, votes := range round.Votes {
+			if value, ok := votes[oldName]; ok {
+				delete(votes, oldName)
+				votes[newName] = value
+				changed = true
+			}
+		}
+
+		if pseudonym, ok := round.Pseudonyms[oldName]; ok {
+			delete(round.Pseudonyms, oldName)
+			round.Pseudonyms[newName] = pseudonym
+			changed = true
+		}
+
+		for _, event := range round.VoteEvents {
+			if event.Player == oldName {
+				event.Player = newName
+				changed = true
+			}
+		}
+	}
+
+	for _, story := range session.allStories() {
+		if renameUserInStory(story, user, newName) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func renameUserInStory(story *Story, user *User, newName string) bool {
+	changed := false
+
+	if story.AuthorID == user.ID && story.Author != newName {
+		story.Author = newName
+		changed = true
+	}
+
+	for _, iteration := range story.Iterations {
+		if value, ok := iteration.Votes[user.Username]; ok {
+			delete(iteration.Votes, user.Username)
+			iteration.Votes[newName] = value
+			changed = true
+		}
+	}
+
+	for i, player := range story.Outliers {
+		if player == user.Username {
+			story.Outliers[i] = newName
+			changed = true
+		}
+	}
+
+	for _, justification := range story.Justifications {
+		if justification.Player == user.Username {
+			justification.Player = newName
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// allRounds returns the past rounds of the session followed by the current one.
+func (s *Session) allRounds() []*Round {
+	rounds := append([]*Round{}, s.RoundHistory...)
+	if s.CurrentRound != nil {
+		rounds = append(rounds, s.CurrentRound)
+	}
+	return rounds
+}
+
+// allStories returns the stories of every round of the session and of its
+// backlog.
+func (s *Session) allStories() []*Story {
+	var stories []*Story
+	for _, round := range s.allRounds() {
+		stories = append(stories, round.Stories...)
+	}
+	return append(stories, s.Backlog...)
+}
+
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	if payload.CurrentPassword == "" || payload.NewPassword == "" {
+		http.Error(w, "Obecne i nowe hasło są wymagane", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPassword(user.Password, payload.CurrentPassword) {
+		http.Error(w, "Błędne hasło", http.StatusUnauthorized)
+		return
+	}
+
+	if violations := policy.checkPassword(payload.NewPassword, user.Username); len(violations) > 0 {
+		writePolicyViolations(w, violations)
+		return
+	}
+
+	token, err := generateJWT(user.ID, user.Username)
+	if err != nil {
+		http.Error(w, "Błąd podczas generowania tokenu", http.StatusInternalServerError)
+		log.Printf("Błąd przy generowaniu JWT: %v", err)
+		return
+	}
+
+	// Storing the fresh token replaces every token issued before, which signs
+	// the user out everywhere except for the client that changed the password.
+	user.Password = hashPassword(payload.NewPassword)
+	user.Token = token
+	user.TokenTime = time.Now().Unix()
+
+	if err := updateUserPassword(user); err != nil {
+		http.Error(w, "Błąd podczas zmiany hasła", http.StatusInternalServerError)
+		log.Printf("Błąd przy zmianie hasła: %v", err)
+		return
+	}
+
+	log.Printf("Użytkownik %s zmienił hasło.", user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+	}{
+		Token: token,
+	})
+}
+
+func changeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	payload.Username = normalizeUsername(payload.Username)
+
+	if violations := policy.checkUsername(payload.Username); len(violations) > 0 {
+		writePolicyViolations(w, violations)
+		return
+	}
+
+	if payload.Username == user.Username {
+		http.Error(w, "Nowa nazwa jest taka sama jak obecna", http.StatusBadRequest)
+		return
+	}
+
+	// Changing only the letter case keeps the same key, so the account may
+	// match itself.
+	if existing, err := getUserByUsername(payload.Username); err == nil && existing.ID != user.ID {
+		http.Error(w, "Użytkownik już istnieje", http.StatusConflict)
+		return
+	}
+
+	// The username is part of the token claims, so a new token is issued.
+	token, err := generateJWT(user.ID, payload.Username)
+	if err != nil {
+		http.Error(w, "Błąd podczas generowania tokenu", http.StatusInternalServerError)
+		log.Printf("Błąd przy generowaniu JWT: %v", err)
+		return
+	}
+
+	oldUsername := user.Username
+	user.Username = payload.Username
+	user.Token = token
+	user.TokenTime = time.Now().Unix()
+
+	if err := updateUsername(user); err != nil {
+		if errors.Is(err, errUserExists) {
+			http.Error(w, "Użytkownik już istnieje", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Błąd podczas zmiany nazwy użytkownika", http.StatusInternalServerError)
+		log.Printf("Błąd przy zmianie nazwy użytkownika: %v", err)
+		return
+	}
+
+	log.Printf("Użytkownik %s zmienił nazwę na %s.", oldUsername, user.Username)
+
+	// Sessions refer to players by name, so the old name is replaced there
+	// too; otherwise the data export and account deletion would miss it.
+	previous := &User{ID: user.ID, Username: oldUsername}
+	err = updateUserSessions(previous, func(session *Session) bool {
+		return renameUserInSession(session, previous, user.Username)
+	})
+	if err != nil {
+		log.Printf("Błąd przy zmianie nazwy użytkownika %s w sesjach: %v", user.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Avatar   string `json:"avatar"`
+		Token    string `json:"token"`
+	}{
+		ID:       user.ID,
+		Username: user.Username,
+		Avatar:   user.Avatar,
+		Token:    token,
+	})
+}
+
+func changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Email != "" && !isValidEmail(payload.Email) {
+		http.Error(w, "Nieprawidłowy adres e-mail", http.StatusBadRequest)
+		return
+	}
+
+	if err := updateUserEmail(user.ID, payload.Email); err != nil {
+		http.Error(w, "Błąd podczas aktualizacji adresu e-mail", http.StatusInternalServerError)
+		log.Printf("Błąd przy aktualizacji adresu e-mail: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Success bool   `json:"success"`
+		Email   string `json:"email"`
+	}{
+		Success: true,
+		Email:   payload.Email,
+	})
+}
+
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPassword(user.Password, payload.Password) {
+		http.Error(w, "Błędne hasło", http.StatusUnauthorized)
+		return
+	}
+
+	if err := anonymizeUser(user); err != nil {
+		http.Error(w, "Błąd podczas usuwania konta", http.StatusInternalServerError)
+		log.Printf("Błąd przy anonimizacji sesji: %v", err)
+		return
+	}
+
+	if err := deleteUser(user.ID); err != nil {
+		http.Error(w, "Błąd podczas usuwania konta", http.StatusInternalServerError)
+		log.Printf("Błąd przy usuwaniu użytkownika: %v", err)
+		return
+	}
+
+	log.Printf("Konto użytkownika %s zostało usunięte.", user.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func anonymizeUser(user *User) error {
+	anonymizedName := anonymizedPlayerName(user)
+	return updateUserSessions(user, func(session *Session) bool {
+		return anonymizeUserInSession(session, user, anonymizedName)
+	})
+}
+
+// maxUserSessionAttempts limits how many times a session changed during
+// updateUserSessions is loaded again.
+const maxUserSessionAttempts = 5
+
+// updateUserSessions applies change to every session of the user and saves
+// the sessions it changed. The story lists are saved too, so a session
+// changed in the meantime is loaded again instead of undoing that change.
+func updateUserSessions(user *User, change func(*Session) bool) error {
+	sessions, err := getSessionsByUser(user)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		for attempt := 1; ; attempt++ {
+			lists := session.storyLists()
+			if !change(session) {
+				break
+			}
+			saved, err := saveSessionIf(session, lists.condition(), false)
+			if err != nil {
+				return fmt.Errorf("błąd przy zapisie sesji %s: %w", session.ID, err)
+			}
+			if saved {
+				break
+			}
+			if attempt == maxUserSessionAttempts {
+				return fmt.Errorf("sesja %s była zmieniana przy każdej próbie zapisu", session.ID)
+			}
+			if session, err = getSession(session.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
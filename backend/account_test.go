@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestAnonymizeUserInSession(t *testing.T) {
+	user := &User{ID: "u1", Username: "Ala"}
+	session := &Session{
+		Players: []string{"Ala", "Jacek"},
+		CurrentRound: &Round{
+			Votes: map[string]map[string]int{"s1": {"Ala": 3, "Jacek": 5}},
+			Stories: []*Story{
+				{ID: "s1", Title: "Logowanie", Author: "Ala", AuthorID: "u1", Iterations: []*VotingIteration{
+					{Number: 1, Votes: map[string]int{"Ala": 13}},
+				}},
+			},
+		},
+		RoundHistory: []*Round{
+			{Votes: map[string]map[string]int{"s0": {"Ala": 8}, "s2": {"Jacek": 2}}},
+		},
+	}
+
+	if !anonymizeUserInSession(session, user, "usunięty-1234") {
+		t.Fatal("oczekiwano zmiany sesji")
+	}
+
+	if session.Players[0] != "usunięty-1234" || session.Players[1] != "Jacek" {
+		t.Errorf("otrzymano graczy: %v", session.Players)
+	}
+	if _, ok := session.CurrentRound.Votes["s1"]["Ala"]; ok {
+		t.Errorf("głos nie został zanonimizowany: %v", session.CurrentRound.Votes)
+	}
+	if v := session.CurrentRound.Votes["s1"]["usunięty-1234"]; v != 3 {
+		t.Errorf("oczekiwano 3, otrzymano %v", v)
+	}
+	if v := session.RoundHistory[0].Votes["s0"]["usunięty-1234"]; v != 8 {
+		t.Errorf("oczekiwano 8, otrzymano %v", v)
+	}
+	if story := session.CurrentRound.Stories[0]; story.Author != "usunięty-1234" || story.AuthorID != "" {
+		t.Errorf("autor story nie został zanonimizowany: %+v", story)
+	}
+	if v := session.CurrentRound.Stories[0].Iterations[0].Votes["usunięty-1234"]; v != 13 {
+		t.Errorf("głos z iteracji nie został zanonimizowany, otrzymano %v", v)
+	}
+
+	if anonymizeUserInSession(session, user, "x") {
+		t.Error("nie oczekiwano zmian dla nieobecnego gracza")
+	}
+}
+
+func TestRenameUserInSession(t *testing.T) {
+	user := &User{ID: "u1", Username: "Ala"}
+	session := &Session{
+		Players: []string{"Ala", "Jacek"},
+		Members: []string{"u1"},
+		CurrentRound: &Round{
+			Votes:      map[string]map[string]int{"s1": {"Ala": 3, "Jacek": 5}},
+			VoteEvents: []*VoteEvent{{Type: voteEventVoted, StoryID: "s1", Player: "Ala"}},
+			Stories:    []*Story{{ID: "s1", Author: "Ala", AuthorID: "u1", Outliers: []string{"Ala"}}},
+		},
+		Backlog: []*Story{{ID: "b1", Author: "Ala", AuthorID: "u1"}},
+	}
+
+	if !renameUserInSession(session, user, "Alicja") {
+		t.Fatal("oczekiwano zmiany sesji")
+	}
+
+	if session.Players[0] != "Alicja" || session.CurrentRound.Votes["s1"]["Alicja"] != 3 || session.CurrentRound.VoteEvents[0].Player != "Alicja" {
+		t.Errorf("nazwa gracza nie została zmieniona: %v, %v", session.Players, session.CurrentRound.Votes)
+	}
+	story := session.CurrentRound.Stories[0]
+	if story.Author != "Alicja" || story.AuthorID != "u1" || story.Outliers[0] != "Alicja" {
+		t.Errorf("nazwa w story nie została zmieniona: %+v", story)
+	}
+	if session.Backlog[0].Author != "Alicja" || len(session.Members) != 1 {
+		t.Errorf("zmiana nazwy nie powinna usuwać członkostwa: %+v, %v", session.Backlog[0], session.Members)
+	}
+
+	if renameUserInSession(session, user, "Alicja") {
+		t.Error("nie oczekiwano zmian po zmianie nazwy")
+	}
+}
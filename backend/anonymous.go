@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SessionSettings are the options the facilitator can change for a session.
+type SessionSettings struct {
+	// AnonymousVoting hides who voted what. It applies to rounds started
+	// after it was turned on.
+	AnonymousVoting bool `json:"anonymousVoting"`
+	// RetainAttribution keeps player names in the stored votes of anonymous
+	// rounds. Without it names are replaced by pseudonyms once a round ends.
+	RetainAttribution bool `json:"retainAttribution"`
+	// OutlierRule picks the players asked to explain their vote after
+	// a reveal: "min-max" (the default), "stddev" or "none".
+	OutlierRule string `json:"outlierRule,omitempty"`
+}
+
+// pseudonym returns the name shown instead of the player in an anonymous
+// round. Pseudonyms are handed out in the order players first vote.
+func (r *Round) pseudonym(player string) string {
+	if r.Pseudonyms == nil {
+		r.Pseudonyms = make(map[string]string)
+	}
+	if name, ok := r.Pseudonyms[player]; ok {
+		return name
+	}
+	name := fmt.Sprintf("Anonim %d", len(r.Pseudonyms)+1)
+	r.Pseudonyms[player] = name
+	return name
+}
+
+// removeAttribution replaces player names in the votes, the audit trail and
+// the vote iterations of the round with their pseudonyms and forgets which
+// pseudonym belongs to whom.
+func (r *Round) removeAttribution() {
+	if !r.Anonymous || r.AttributionRemoved {
+		return
+	}
+
+	for storyID, votes := range r.Votes {
+		renamed := make(map[string]int, len(votes))
+		for player, value := range votes {
+			renamed[r.pseudonym(player)] = value
+		}
+		r.Votes[storyID] = renamed
+	}
+
+	for _, event := range r.VoteEvents {
+		if event.Player != "" {
+			event.Player = r.pseudonym(event.Player)
+		}
+	}
+
+	for _, story := range r.Stories {
+		removeIterationAttribution(story, r)
+	}
+
+	r.Pseudonyms = nil
+	r.AttributionRemoved = true
+}
+
+func removeIterationAttribution(story *Story, round *Round) {
+	for _, iteration := range story.Iterations {
+		renamed := make(map[string]int, len(iteration.Votes))
+		for player, value := range iteration.Votes {
+			renamed[round.pseudonym(player)] = value
+		}
+		iteration.Votes = renamed
+	}
+
+	for i, player := range story.Outliers {
+		story.Outliers[i] = round.pseudonym(player)
+	}
+
+	for _, justification := range story.Justifications {
+		justification.Player = round.pseudonym(justification.Player)
+	}
+}
+
+// archiveRound prepares the round for the history. Anonymous rounds lose
+// attribution unless the facilitator chose to retain it.
+func (s *Session) archiveRound(round *Round) {
+	if !s.Settings.RetainAttribution {
+		round.removeAttribution()
+	}
+	s.RoundHistory = append(s.RoundHistory, round)
+}
+
+// hideVoters removes attribution from every anonymous round of the session
+// before it is sent to a client. The session must not be saved afterwards.
+func (s *Session) hideVoters() {
+	if s.CurrentRound != nil {
+		s.CurrentRound.removeAttribution()
+	}
+	for _, round := range s.RoundHistory {
+		round.removeAttribution()
+	}
+}
+
+func updateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	var settings SessionSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if !validOutlierRule(settings.OutlierRule) {
+		http.Error(w, "Nieznana reguła wyboru skrajnych głosów", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	session.Settings = settings
+
+	update := bson.M{"$set": bson.M{"settings": settings}}
+	if _, err := updateSessionFields(session.ID, nil, update); err != nil {
+		http.Error(w, "Wystąpił błąd zapisu", http.StatusInternalServerError)
+		return
+	}
+
+	notifySessionParticipants(sessionID, "/settings-updated")
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(session.Settings)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func anonymousSession(retain bool) *Session {
+	five, eight := 5, 8
+	round := &Round{
+		ID:        "round-1",
+		Anonymous: true,
+		Votes:     map[string]map[string]int{"a": {"Ala": 5, "Jacek": 8}},
+		Stories: []*Story{{ID: "a", Iterations: []*VotingIteration{
+			{Number: 1, Votes: map[string]int{"Ala": 3}},
+		}}},
+		VoteEvents: []*VoteEvent{
+			{Type: voteEventVoted, StoryID: "a", Player: "Jacek", Value: &eight},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &five},
+		},
+	}
+	round.pseudonym("Jacek")
+	round.pseudonym("Ala")
+
+	return &Session{
+		ID:           "session-1",
+		Players:      []string{"Ala", "Jacek"},
+		Settings:     SessionSettings{AnonymousVoting: true, RetainAttribution: retain},
+		CurrentRound: round,
+	}
+}
+
+func TestHideVotersKeepsDistribution(t *testing.T) {
+	session := anonymousSession(false)
+	session.hideVoters()
+
+	data, err := json.Marshal(session.CurrentRound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Ala") || strings.Contains(string(data), "Jacek") {
+		t.Errorf("odpowiedź zawiera nazwy graczy: %s", data)
+	}
+
+	votes := session.CurrentRound.Votes["a"]
+	if votes["Anonim 1"] != 8 || votes["Anonim 2"] != 5 {
+		t.Errorf("oczekiwano głosów pod pseudonimami, otrzymano %v", votes)
+	}
+}
+
+func TestArchiveRoundRetainsAttributionOnlyWhenAsked(t *testing.T) {
+	session := anonymousSession(true)
+	session.archiveRound(session.CurrentRound)
+	if _, ok := session.RoundHistory[0].Votes["a"]["Ala"]; !ok {
+		t.Error("historia powinna zachować nazwy graczy")
+	}
+
+	session = anonymousSession(false)
+	session.archiveRound(session.CurrentRound)
+	archived := session.RoundHistory[0]
+	if _, ok := archived.Votes["a"]["Ala"]; ok {
+		t.Error("historia nie powinna zachować nazw graczy")
+	}
+	if archived.Pseudonyms != nil {
+		t.Error("przypisanie pseudonimów nie powinno zostać zapisane")
+	}
+	if archived.Stories[0].Iterations[0].Votes["Anonim 2"] != 3 {
+		t.Errorf("iteracje powinny używać pseudonimów, otrzymano %v", archived.Stories[0].Iterations[0].Votes)
+	}
+}
+
+func TestNextRoundDoesNotChangeArchivedStories(t *testing.T) {
+	session := anonymousSession(false)
+	story := session.CurrentRound.Stories[0]
+	story.Outliers = []string{"Ala"}
+	story.Justifications = []*Justification{{Player: "Ala", Vote: 5, Text: "Ryzyko"}}
+
+	startNextRound(session)
+	archived := session.RoundHistory[0].Stories[0]
+	carried := session.CurrentRound.Stories[0]
+	if carried == archived {
+		t.Fatal("przeniesiona story nie powinna być tą samą story co w historii")
+	}
+
+	session.hideVoters()
+
+	if archived.Iterations[0].Votes["Anonim 2"] != 3 || len(archived.Iterations[0].Votes) != 1 {
+		t.Errorf("iteracje zarchiwizowanej rundy zmieniły się: %v", archived.Iterations[0].Votes)
+	}
+	if archived.Outliers[0] != "Anonim 2" || archived.Justifications[0].Player != "Anonim 2" {
+		t.Errorf("zarchiwizowana runda zmieniła się: %v, %+v", archived.Outliers, archived.Justifications[0])
+	}
+	if carried.Iterations[0].Votes["Anonim 1"] != 3 {
+		t.Errorf("nowa runda powinna użyć własnych pseudonimów: %v", carried.Iterations[0].Votes)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	errStoryNotInBacklog = errors.New("user story nie znaleziona w backlogu")
+	errStoryNotInRound   = errors.New("user story nie znaleziona w rundzie")
+)
+
+// pullFromBacklog moves the story from the session backlog to the end of the
+// current round.
+func pullFromBacklog(session *Session, storyID string) (*Story, error) {
+	index := session.backlogIndex(storyID)
+	if index < 0 {
+		return nil, errStoryNotInBacklog
+	}
+
+	story := session.Backlog[index]
+	session.Backlog = append(session.Backlog[:index], session.Backlog[index+1:]...)
+	session.BacklogVersion++
+
+	round := session.CurrentRound
+	round.Stories = append(round.Stories, story)
+	round.StoriesVersion++
+	if round.ActiveStory == "" {
+		round.setActiveStory(story.ID)
+	}
+
+	return story, nil
+}
+
+// pushToBacklog moves the story from the current round back to the end of the
+// session backlog. Votes cast for it in the round are dropped.
+func pushToBacklog(session *Session, storyID string) (*Story, error) {
+	story := session.CurrentRound.removeStory(storyID)
+	if story == nil {
+		return nil, errStoryNotInRound
+	}
+
+	// The backlog outlives the round and its pseudonyms.
+	if session.CurrentRound.Anonymous {
+		removeIterationAttribution(story, session.CurrentRound)
+	}
+
+	session.Backlog = append(session.Backlog, story)
+	session.BacklogVersion++
+	return story, nil
+}
+
+func addBacklogStoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	var payload struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Author      string `json:"author"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Title == "" {
+		http.Error(w, "Tytuł user story jest wymagany", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	story := newStory(payload.Title, payload.Description, payload.Author)
+	if user, _, err := authenticate(r); err == nil {
+		story.Author = user.Username
+		story.AuthorID = user.ID
+	}
+
+	// The session may have been closed after the request was let through.
+	saved, err := appendBacklogStory(sessionID, bson.M{"status": openStatusFilter()}, story, true)
+	if err != nil {
+		http.Error(w, "Błąd przy dodawaniu user story", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Sesja została w międzyczasie zamknięta", http.StatusConflict)
+		return
+	}
+	session.Backlog = append(session.Backlog, story)
+	session.BacklogVersion++
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/backlog-added:%s", story.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeBacklog(w, session)
+}
+
+func deleteBacklogStoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	index := session.backlogIndex(storyID)
+	if index < 0 {
+		http.Error(w, errStoryNotInBacklog.Error(), http.StatusNotFound)
+		return
+	}
+
+	lists := session.storyLists()
+	session.Backlog = append(session.Backlog[:index], session.Backlog[index+1:]...)
+	session.BacklogVersion++
+
+	if !saveSessionChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/backlog-removed:%s", storyID))
+	w.Header().Set("Content-Type", "application/json")
+	writeBacklog(w, session)
+}
+
+func pullStoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	if session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return
+	}
+
+	lists := session.storyLists()
+	if _, err := pullFromBacklog(session, storyID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !saveSessionChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-pulled:%s", storyID))
+	session.hideVoters()
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
+
+func pushStoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	if session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return
+	}
+
+	lists := session.storyLists()
+	if _, err := pushToBacklog(session, storyID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !saveSessionChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-pushed:%s", storyID))
+	session.hideVoters()
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(session)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeBacklog(w http.ResponseWriter, session *Session) {
+	backlog := session.Backlog
+	if backlog == nil {
+		backlog = []*Story{}
+	}
+	err := json.NewEncoder(w).Encode(backlog)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPullAndPushBacklogStories(t *testing.T) {
+	story := newStory("Logowanie", "", "Ala")
+	session := &Session{
+		ID:           "session-1",
+		Backlog:      []*Story{story},
+		CurrentRound: &Round{ID: "round-1", Votes: map[string]map[string]int{}},
+	}
+
+	if _, err := pullFromBacklog(session, story.ID); err != nil {
+		t.Fatalf("nieoczekiwany błąd: %v", err)
+	}
+	if len(session.Backlog) != 0 || session.CurrentRound.findStory(story.ID) == nil {
+		t.Fatal("user story powinna trafić z backlogu do rundy")
+	}
+	if session.CurrentRound.ActiveStory != story.ID {
+		t.Errorf("oczekiwano aktywnej user story %s, otrzymano %s", story.ID, session.CurrentRound.ActiveStory)
+	}
+
+	session.CurrentRound.Votes[story.ID] = map[string]int{"Ala": 5}
+	if _, err := pushToBacklog(session, story.ID); err != nil {
+		t.Fatalf("nieoczekiwany błąd: %v", err)
+	}
+	if len(session.Backlog) != 1 || len(session.CurrentRound.Stories) != 0 {
+		t.Fatal("user story powinna wrócić do backlogu")
+	}
+	if _, ok := session.CurrentRound.Votes[story.ID]; ok {
+		t.Error("głosy na user story przeniesioną do backlogu powinny zostać usunięte")
+	}
+
+	if _, err := pullFromBacklog(session, "brak"); err != errStoryNotInBacklog {
+		t.Errorf("oczekiwano errStoryNotInBacklog, otrzymano %v", err)
+	}
+}
+
+func TestUnestimatedStoriesCarryOver(t *testing.T) {
+	five := 5
+	round := &Round{
+		ID: "round-1",
+		Stories: []*Story{
+			{ID: "a", FinalEstimate: &five},
+			{ID: "b"},
+		},
+	}
+
+	carried := round.unestimatedStories()
+	if len(carried) != 1 || carried[0].ID != "b" {
+		t.Errorf("oczekiwano przeniesienia tylko user story b, otrzymano %+v", carried)
+	}
+}
+
+func TestAddBacklogStoryChecksFacilitatorAndStatus(t *testing.T) {
+	requireMongo(t)
+
+	add := func(session *Session) int {
+		req := httptest.NewRequest("POST", "/sessions/"+session.ID+"/backlog", strings.NewReader(`{"title": "Eksport"}`))
+		req = mux.SetURLVars(req, map[string]string{"id": session.ID})
+		rr := httptest.NewRecorder()
+		addBacklogStoryHandler(rr, req)
+		return rr.Code
+	}
+
+	led := &Session{Name: "Z prowadzącym"}
+	prepareSession(led, "facilitator-1")
+	if err := saveSession(led); err != nil {
+		t.Fatal(err)
+	}
+	if code := add(led); code != http.StatusUnauthorized {
+		t.Errorf("Oczekiwano %d bez logowania, otrzymano %d", http.StatusUnauthorized, code)
+	}
+
+	// The session is closed after the middleware let the request through.
+	closed := &Session{Name: "Zamknięta"}
+	prepareSession(closed, "")
+	closed.Status = sessionClosed
+	if err := saveSession(closed); err != nil {
+		t.Fatal(err)
+	}
+	if code := add(closed); code != http.StatusConflict {
+		t.Errorf("Oczekiwano %d dla zamkniętej sesji, otrzymano %d", http.StatusConflict, code)
+	}
+	if stored, _ := getSession(closed.ID); len(stored.Backlog) != 0 {
+		t.Errorf("User story trafiła do zamkniętej sesji: %+v", stored.Backlog)
+	}
+}
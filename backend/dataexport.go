@@ -0,0 +1,377 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const dataExportTTL = time.Hour
+
+const (
+	dataExportPending = "pending"
+	dataExportReady   = "ready"
+	dataExportFailed  = "failed"
+)
+
+// dataExportJob is a personal data export prepared in the background. Jobs live
+// only in memory and are dropped after dataExportTTL.
+type dataExportJob struct {
+	ID        string
+	UserID    string
+	Format    string
+	Status    string
+	Data      []byte
+	CreatedAt time.Time
+}
+
+var (
+	dataExportsMu sync.Mutex
+	dataExports   = make(map[string]*dataExportJob)
+)
+
+type personalDataExport struct {
+	GeneratedAt    time.Time               `json:"generatedAt"`
+	User           exportedUser            `json:"user"`
+	Sessions       []exportedSessionEntry  `json:"sessions"`
+	Votes          []exportedVote          `json:"votes"`
+	VoteEvents     []exportedVoteEvent     `json:"voteEvents"`
+	Justifications []exportedJustification `json:"justifications"`
+	Stories        []exportedStory         `json:"stories"`
+}
+
+type exportedUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+	Email    string `json:"email,omitempty"`
+}
+
+type exportedSessionEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type exportedVote struct {
+	SessionID string `json:"sessionId"`
+	RoundID   string `json:"roundId"`
+	StoryID   string `json:"storyId,omitempty"`
+	Story     string `json:"story,omitempty"`
+	// Iteration is set for votes archived by a re-vote.
+	Iteration int `json:"iteration,omitempty"`
+	Vote      int `json:"vote"`
+}
+
+type exportedVoteEvent struct {
+	SessionID string    `json:"sessionId"`
+	RoundID   string    `json:"roundId"`
+	StoryID   string    `json:"storyId"`
+	Type      string    `json:"type"`
+	Value     *int      `json:"value,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type exportedJustification struct {
+	SessionID string    `json:"sessionId"`
+	StoryID   string    `json:"storyId"`
+	Story     string    `json:"story"`
+	Iteration int       `json:"iteration"`
+	Vote      int       `json:"vote"`
+	Text      string    `json:"text"`
+	At        time.Time `json:"at"`
+}
+
+type exportedStory struct {
+	SessionID   string    `json:"sessionId"`
+	RoundID     string    `json:"roundId,omitempty"`
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// buildPersonalDataExport collects everything tied to the user from the given
+// sessions. Password hashes and tokens are never part of the export.
+func buildPersonalDataExport(user *User, sessions []*Session) *personalDataExport {
+	export := &personalDataExport{
+		GeneratedAt: time.Now().UTC(),
+		User: exportedUser{
+			ID:       user.ID,
+			Username: user.Username,
+			Avatar:   user.Avatar,
+			Email:    user.Email,
+		},
+		Sessions:       []exportedSessionEntry{},
+		Votes:          []exportedVote{},
+		VoteEvents:     []exportedVoteEvent{},
+		Justifications: []exportedJustification{},
+		Stories:        []exportedStory{},
+	}
+
+	exportedIterations := make(map[string]bool)
+
+	// Carried over stories are in several rounds and possibly in the backlog.
+	// Like in buildSessionResults they are exported once, in the latest state.
+	exportedStories := make(map[string]int)
+	addStory := func(sessionID, roundID string, story *Story) {
+		entry := exportedStory{
+			SessionID:   sessionID,
+			RoundID:     roundID,
+			ID:          story.ID,
+			Title:       story.Title,
+			Description: story.Description,
+			CreatedAt:   story.CreatedAt,
+		}
+		if i, ok := exportedStories[story.ID]; ok {
+			if roundID == "" {
+				entry.RoundID = export.Stories[i].RoundID
+			}
+			export.Stories[i] = entry
+			return
+		}
+		exportedStories[story.ID] = len(export.Stories)
+		export.Stories = append(export.Stories, entry)
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, exportedSessionEntry{
+			ID:   session.ID,
+			Name: session.Name,
+		})
+
+		rounds := append([]*Round{}, session.RoundHistory...)
+		if session.CurrentRound != nil {
+			rounds = append(rounds, session.CurrentRound)
+		}
+
+		for _, round := range rounds {
+			for storyID, votes := range round.Votes {
+				value, ok := votes[user.Username]
+				if !ok {
+					continue
+				}
+				vote := exportedVote{
+					SessionID: session.ID,
+					RoundID:   round.ID,
+					Vote:      value,
+				}
+				if story := round.findStory(storyID); story != nil {
+					vote.StoryID = story.ID
+					vote.Story = story.Title
+				}
+				export.Votes = append(export.Votes, vote)
+			}
+
+			for _, event := range round.VoteEvents {
+				if event.Player != user.Username {
+					continue
+				}
+				export.VoteEvents = append(export.VoteEvents, exportedVoteEvent{
+					SessionID: session.ID,
+					RoundID:   round.ID,
+					StoryID:   event.StoryID,
+					Type:      event.Type,
+					Value:     event.Value,
+					At:        event.At,
+				})
+			}
+
+			for _, story := range round.Stories {
+				for _, iteration := range story.Iterations {
+					value, ok := iteration.Votes[user.Username]
+					// Carried over stories keep their iterations in every round.
+					key := fmt.Sprintf("%s/%d", story.ID, iteration.Number)
+					if !ok || exportedIterations[key] {
+						continue
+					}
+					exportedIterations[key] = true
+					export.Votes = append(export.Votes, exportedVote{
+						SessionID: session.ID,
+						RoundID:   round.ID,
+						StoryID:   story.ID,
+						Story:     story.Title,
+						Iteration: iteration.Number,
+						Vote:      value,
+					})
+				}
+
+				for _, justification := range story.Justifications {
+					key := fmt.Sprintf("%s/%d/justification", story.ID, justification.Iteration)
+					if justification.Player != user.Username || exportedIterations[key] {
+						continue
+					}
+					exportedIterations[key] = true
+					export.Justifications = append(export.Justifications, exportedJustification{
+						SessionID: session.ID,
+						StoryID:   story.ID,
+						Story:     story.Title,
+						Iteration: justification.Iteration,
+						Vote:      justification.Vote,
+						Text:      justification.Text,
+						At:        justification.At,
+					})
+				}
+
+				if story.AuthorID == user.ID {
+					addStory(session.ID, round.ID, story)
+				}
+			}
+		}
+
+		for _, story := range session.Backlog {
+			if story.AuthorID == user.ID {
+				addStory(session.ID, "", story)
+			}
+		}
+	}
+
+	return export
+}
+
+func encodeDataExport(export *personalDataExport, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if format != "zip" {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create("kat-poker-data.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func runDataExport(job *dataExportJob, user *User) {
+	status := dataExportReady
+
+	sessions, err := getSessionsByUser(user)
+	var data []byte
+	if err == nil {
+		data, err = encodeDataExport(buildPersonalDataExport(user, sessions), job.Format)
+	}
+	if err != nil {
+		log.Printf("Błąd przy eksporcie danych użytkownika %s: %v", user.ID, err)
+		status = dataExportFailed
+	}
+
+	dataExportsMu.Lock()
+	job.Status = status
+	job.Data = data
+	dataExportsMu.Unlock()
+}
+
+func dropExpiredDataExports() {
+	for id, job := range dataExports {
+		if time.Since(job.CreatedAt) > dataExportTTL {
+			delete(dataExports, id)
+		}
+	}
+}
+
+func requestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		http.Error(w, "Nieobsługiwany format eksportu", http.StatusBadRequest)
+		return
+	}
+
+	job := &dataExportJob{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Format:    format,
+		Status:    dataExportPending,
+		CreatedAt: time.Now(),
+	}
+
+	dataExportsMu.Lock()
+	dropExpiredDataExports()
+	dataExports[job.ID] = job
+	dataExportsMu.Unlock()
+
+	go runDataExport(job, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{
+		ID:     job.ID,
+		Status: job.Status,
+	})
+}
+
+func getDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	jobID := mux.Vars(r)["jobId"]
+
+	dataExportsMu.Lock()
+	job, ok := dataExports[jobID]
+	var status, format string
+	var data []byte
+	if ok {
+		status, format, data = job.Status, job.Format, job.Data
+	}
+	dataExportsMu.Unlock()
+
+	if !ok || job.UserID != user.ID {
+		http.Error(w, "Eksport nie znaleziony", http.StatusNotFound)
+		return
+	}
+
+	switch status {
+	case dataExportPending:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		}{
+			ID:     jobID,
+			Status: status,
+		})
+	case dataExportFailed:
+		http.Error(w, "Błąd podczas przygotowania eksportu", http.StatusInternalServerError)
+	default:
+		contentType := "application/json"
+		if format == "zip" {
+			contentType = "application/zip"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"kat-poker-data.%s\"", format))
+		_, _ = w.Write(data)
+	}
+}
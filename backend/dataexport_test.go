@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBuildPersonalDataExport(t *testing.T) {
+	user := &User{ID: "u1", Username: "Ala", Password: "hash", Token: "secret", Avatar: "🎲"}
+	sessions := []*Session{
+		{
+			ID:      "session-1",
+			Name:    "Sprint 1",
+			Players: []string{"Ala", "Jacek"},
+			CurrentRound: &Round{
+				ID: "round-2",
+				Stories: []*Story{
+					{ID: "s1", Title: "Logowanie", AuthorID: "u1"},
+					{ID: "s2", Title: "Rejestracja", AuthorID: "u2"},
+				},
+				Votes: map[string]map[string]int{"s1": {"Ala": 5, "Jacek": 3}},
+			},
+			RoundHistory: []*Round{
+				{ID: "round-1", Votes: map[string]map[string]int{"s0": {"Jacek": 8}}},
+			},
+		},
+	}
+
+	export := buildPersonalDataExport(user, sessions)
+
+	if len(export.Sessions) != 1 || export.Sessions[0].ID != "session-1" {
+		t.Errorf("otrzymano sesje: %v", export.Sessions)
+	}
+	if len(export.Votes) != 1 {
+		t.Fatalf("oczekiwano 1 głosu, otrzymano %v", export.Votes)
+	}
+	if v := export.Votes[0]; v.RoundID != "round-2" || v.StoryID != "s1" || v.Story != "Logowanie" || v.Vote != 5 {
+		t.Errorf("otrzymano głos: %+v", v)
+	}
+	if len(export.Stories) != 1 || export.Stories[0].ID != "s1" {
+		t.Errorf("oczekiwano jednej story autorstwa użytkownika, otrzymano %v", export.Stories)
+	}
+
+	data, err := encodeDataExport(export, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "hash") {
+		t.Errorf("eksport zawiera dane uwierzytelniające: %s", data)
+	}
+}
+
+func TestBuildPersonalDataExportCarriedOverStory(t *testing.T) {
+	user := &User{ID: "u1", Username: "Ala"}
+	first := &Story{ID: "s1", Title: "Logowanie", AuthorID: "u1"}
+	carried := &Story{ID: "s1", Title: "Logowanie przez SSO", AuthorID: "u1"}
+	sessions := []*Session{
+		{
+			ID:           "session-1",
+			RoundHistory: []*Round{{ID: "round-1", Stories: []*Story{first}}},
+			CurrentRound: &Round{ID: "round-2", Stories: []*Story{carried}},
+			Backlog:      []*Story{carried, {ID: "s2", Title: "Rejestracja", AuthorID: "u1"}},
+		},
+	}
+
+	export := buildPersonalDataExport(user, sessions)
+
+	if len(export.Stories) != 2 {
+		t.Fatalf("oczekiwano 2 stories bez powtórzeń, otrzymano %+v", export.Stories)
+	}
+	if s := export.Stories[0]; s.ID != "s1" || s.RoundID != "round-2" || s.Title != "Logowanie przez SSO" {
+		t.Errorf("przeniesiona story powinna mieć ostatni stan, otrzymano %+v", s)
+	}
+	if s := export.Stories[1]; s.ID != "s2" || s.RoundID != "" {
+		t.Errorf("otrzymano story z backlogu: %+v", s)
+	}
+}
+
+func TestEncodeDataExportZip(t *testing.T) {
+	export := buildPersonalDataExport(&User{ID: "u1", Username: "Ala"}, nil)
+
+	data, err := encodeDataExport(export, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "kat-poker-data.json" {
+		t.Fatalf("nieoczekiwana zawartość archiwum: %v", archive.File)
+	}
+
+	file, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var decoded personalDataExport
+	if err := json.NewDecoder(file).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.User.Username != "Ala" {
+		t.Errorf("otrzymano: %+v", decoded.User)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 	"os"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -13,6 +15,15 @@ var (
 	client     *mongo.Client
 	sessionCol *mongo.Collection
 	userCol    *mongo.Collection
+	resetCol   *mongo.Collection
+	teamCol    *mongo.Collection
+	webhookCol *mongo.Collection
+	// deliveryCol is the queue of outgoing webhook requests.
+	deliveryCol *mongo.Collection
+	// inboundEventCol remembers the tracker webhooks already handled.
+	inboundEventCol *mongo.Collection
+	chatLinkCol     *mongo.Collection
+	chatLinkCodeCol *mongo.Collection
 )
 
 func initMongoDB() {
@@ -38,7 +49,229 @@ func initMongoDB() {
 		log.Fatalf("MongoDB ping error: %v", err)
 	}
 
-	sessionCol = client.Database("planning").Collection("sessions")
-	userCol = client.Database("planning").Collection("users")
+	useDatabase(client.Database("planning"))
 	log.Println("Connected to MongoDB")
+
+	if err := ensureIndexes(ctx); err != nil {
+		log.Fatalf("MongoDB index error: %v", err)
+	}
+}
+
+// useDatabase points the collections at db. Tests use it with a database of
+// their own.
+func useDatabase(db *mongo.Database) {
+	sessionCol = db.Collection("sessions")
+	userCol = db.Collection("users")
+	resetCol = db.Collection("password_resets")
+	teamCol = db.Collection("teams")
+	webhookCol = db.Collection("webhooks")
+	deliveryCol = db.Collection("webhook_deliveries")
+	inboundEventCol = db.Collection("inbound_events")
+	chatLinkCol = db.Collection("chat_links")
+	chatLinkCodeCol = db.Collection("chat_link_codes")
+}
+
+// indexModels are the indexes the application relies on, by collection.
+func indexModels() map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		"users": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				// Accounts created before usernames were normalized have no key.
+				Keys: bson.D{{Key: "usernamekey", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"usernamekey": bson.M{"$gt": ""}}),
+			},
+		},
+		"sessions": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				// Used by the sweeper closing inactive sessions.
+				Keys: bson.D{{Key: "status", Value: 1}, {Key: "lastactivityat", Value: 1}},
+			},
+			{Keys: bson.D{{Key: "members", Value: 1}}},
+			{
+				// Searched by GET /me/sessions. MongoDB has no Polish stemming,
+				// so words are matched as written.
+				Keys: bson.D{
+					{Key: "name", Value: "text"},
+					{Key: "currentround.stories.title", Value: "text"},
+					{Key: "roundhistory.stories.title", Value: "text"},
+					{Key: "backlog.title", Value: "text"},
+				},
+				Options: options.Index().
+					SetName("session_search").
+					SetDefaultLanguage("none").
+					SetWeights(bson.D{{Key: "name", Value: 5}}),
+			},
+		},
+		"teams": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		"webhooks": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "sessionid", Value: 1}}},
+		},
+		"webhook_deliveries": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}}},
+			{Keys: bson.D{{Key: "subscriptionid", Value: 1}, {Key: "createdat", Value: -1}}},
+		},
+		"inbound_events": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "receivedat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(int32(inboundEventTTL / time.Second)),
+			},
+		},
+		"chat_links": {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "userid", Value: 1}}},
+		},
+		"chat_link_codes": {
+			{
+				Keys:    bson.D{{Key: "codehash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"password_resets": {
+			{
+				Keys:    bson.D{{Key: "tokenhash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				// Expired reset links are removed by MongoDB.
+				Keys:    bson.D{{Key: "expiresat", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	}
+}
+
+// uniqueFields returns the fields of the collection with a unique index of
+// their own. Empty values are left out of those indexes.
+func uniqueFields(collection string) []string {
+	var fields []string
+	for _, model := range indexModels()[collection] {
+		keys := model.Keys.(bson.D)
+		if len(keys) == 1 && model.Options != nil && model.Options.Unique != nil && *model.Options.Unique {
+			fields = append(fields, keys[0].Key)
+		}
+	}
+	return fields
+}
+
+// ensureIndexes creates the indexes the application relies on. Creating an
+// index that already exists is a no-op, so it is safe to run on every start.
+func ensureIndexes(ctx context.Context) error {
+	db := sessionCol.Database()
+	for name, models := range indexModels() {
+		col := db.Collection(name)
+		models, err := skipDuplicatedUniqueIndexes(ctx, col, models)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := col.Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	log.Println("MongoDB indexes are up to date")
+	return nil
+}
+
+// skipDuplicatedUniqueIndexes leaves out the new unique indexes that existing
+// documents would break, e.g. users registered twice before usernames were
+// unique. Creating them would fail and stop the server, so the duplicates are
+// logged instead and the index is created on a later start, once they are
+// fixed as described in the README.
+func skipDuplicatedUniqueIndexes(ctx context.Context, col *mongo.Collection, models []mongo.IndexModel) ([]mongo.IndexModel, error) {
+	specs, err := col.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for _, spec := range specs {
+		existing[spec.Name] = true
+	}
+
+	var result []mongo.IndexModel
+	for _, model := range models {
+		keys := model.Keys.(bson.D)
+		unique := model.Options != nil && model.Options.Unique != nil && *model.Options.Unique
+		if !unique || len(keys) != 1 || existing[keys[0].Key+"_1"] {
+			result = append(result, model)
+			continue
+		}
+
+		duplicates, err := duplicateValues(ctx, col, keys[0].Key, model.Options.PartialFilterExpression)
+		if err != nil {
+			return nil, err
+		}
+		if len(duplicates) > 0 {
+			log.Printf("Skipping unique index on %s.%s, duplicated values: %v", col.Name(), keys[0].Key, duplicates)
+			continue
+		}
+		result = append(result, model)
+	}
+	return result, nil
+}
+
+// duplicateValues returns up to 20 values of the field shared by several
+// documents matching filter.
+func duplicateValues(ctx context.Context, col *mongo.Collection, field string, filter interface{}) ([]interface{}, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 20}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Value interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(groups))
+	for _, group := range groups {
+		values = append(values, group.Value)
+	}
+	return values, nil
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSkipDuplicatedUniqueIndexes(t *testing.T) {
+	requireMongo(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := sessionCol.Database().Collection("users_duplicates")
+	defer col.Drop(context.Background())
+	_, err := col.InsertMany(ctx, []interface{}{
+		bson.M{"id": "u1", "username": "Ala", "usernamekey": "ala"},
+		bson.M{"id": "u2", "username": "ala", "usernamekey": "ala"},
+		bson.M{"id": "u3", "username": "Jacek"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models, err := skipDuplicatedUniqueIndexes(ctx, col, indexModels()["users"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("oczekiwano pominięcia indeksu usernamekey, otrzymano %d indeksów", len(models))
+	}
+	for _, model := range models {
+		if model.Keys.(bson.D)[0].Key == "usernamekey" {
+			t.Errorf("indeks usernamekey nie został pominięty")
+		}
+	}
+	if _, err := col.Indexes().CreateMany(ctx, models); err != nil {
+		t.Errorf("pozostałe indeksy powinny się utworzyć: %v", err)
+	}
+}
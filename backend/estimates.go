@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const maxEstimateNoteLength = 1000
+
+// consensusValue returns the vote every player agreed on. There is no
+// consensus without votes or when at least two votes differ.
+func consensusValue(votes map[string]int) (int, bool) {
+	first := true
+	value := 0
+	for _, v := range votes {
+		if first {
+			value = v
+			first = false
+			continue
+		}
+		if v != value {
+			return 0, false
+		}
+	}
+	return value, !first
+}
+
+func setEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	var payload struct {
+		// Value defaults to the consensus of the votes for the story.
+		Value *int   `json:"value"`
+		Note  string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if len([]rune(payload.Note)) > maxEstimateNoteLength {
+		http.Error(w, "Uzasadnienie jest za długie", http.StatusBadRequest)
+		return
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	if payload.Value == nil {
+		value, ok := consensusValue(session.CurrentRound.Votes[storyID])
+		if !ok {
+			http.Error(w, "Brak konsensusu, podaj wartość estymaty", http.StatusBadRequest)
+			return
+		}
+		payload.Value = &value
+	}
+
+	if *payload.Value < 0 {
+		http.Error(w, "Estymata nie może być ujemna", http.StatusBadRequest)
+		return
+	}
+
+	lists := session.storyLists()
+	story.FinalEstimate = payload.Value
+	story.EstimateNote = payload.Note
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-estimated:%s", storyID))
+	pushEstimateInBackground(session, story)
+	publishEvent(session, eventStoryEstimated, storyEstimatedData{
+		RoundID:       session.CurrentRound.ID,
+		StoryID:       story.ID,
+		Title:         story.Title,
+		ExternalKey:   story.ExternalKey,
+		FinalEstimate: *story.FinalEstimate,
+		Note:          story.EstimateNote,
+	})
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+func clearEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	lists := session.storyLists()
+	story.FinalEstimate = nil
+	story.EstimateNote = ""
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-estimated:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+// revoteHandler archives the votes for the active story as a new iteration and
+// clears them, so that the players can vote again after a discussion.
+func revoteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	if session.CurrentRound == nil {
+		http.Error(w, "Runda nie została rozpoczęta", http.StatusBadRequest)
+		return
+	}
+
+	story := session.CurrentRound.findStory(session.CurrentRound.ActiveStory)
+	if story == nil {
+		http.Error(w, "Brak aktywnej user story", http.StatusBadRequest)
+		return
+	}
+
+	votes := session.CurrentRound.Votes[story.ID]
+	if len(votes) == 0 {
+		http.Error(w, "Brak głosów do ponownego głosowania", http.StatusBadRequest)
+		return
+	}
+
+	lists := session.storyLists()
+	story.archiveVotes(votes)
+	story.Outliers = nil
+	delete(session.CurrentRound.Votes, story.ID)
+	session.CurrentRound.recordVoteEvent(voteEventArchived, "", nil)
+	session.CurrentRound.setActiveStory(story.ID)
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/revote:%s", story.ID))
+	session.hideVoters()
+	writeStory(w, story)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConsensusValue(t *testing.T) {
+	if _, ok := consensusValue(nil); ok {
+		t.Error("brak głosów nie może dawać konsensusu")
+	}
+	if v, ok := consensusValue(map[string]int{"Ala": 5, "Jacek": 5}); !ok || v != 5 {
+		t.Errorf("oczekiwano konsensusu 5, otrzymano %v %v", v, ok)
+	}
+	if _, ok := consensusValue(map[string]int{"Ala": 5, "Jacek": 8}); ok {
+		t.Error("różne głosy nie mogą dawać konsensusu")
+	}
+}
+
+func TestRoundCommittedPoints(t *testing.T) {
+	three, five := 3, 5
+	round := &Round{
+		ID: "round-1",
+		Stories: []*Story{
+			{ID: "a", FinalEstimate: &three},
+			{ID: "b", FinalEstimate: &five},
+			{ID: "c"},
+		},
+	}
+
+	data, err := json.Marshal(round)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		CommittedPoints int `json:"committedPoints"`
+		Stories         []struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"stories"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.CommittedPoints != 8 {
+		t.Errorf("oczekiwano 8 punktów, otrzymano %d", decoded.CommittedPoints)
+	}
+	if decoded.Stories[0].Status != storyEstimated || decoded.Stories[2].Status != storyUnestimated {
+		t.Errorf("nieoczekiwane statusy: %+v", decoded.Stories)
+	}
+}
+
+func TestArchiveVotesNumbersIterations(t *testing.T) {
+	story := &Story{ID: "a"}
+	votes := map[string]int{"Ala": 3, "Jacek": 8}
+
+	story.archiveVotes(votes)
+	votes["Ala"] = 5
+	story.archiveVotes(votes)
+
+	if len(story.Iterations) != 2 {
+		t.Fatalf("oczekiwano 2 iteracji, otrzymano %d", len(story.Iterations))
+	}
+	if story.Iterations[0].Number != 1 || story.Iterations[1].Number != 2 {
+		t.Errorf("nieoczekiwane numery iteracji: %d, %d", story.Iterations[0].Number, story.Iterations[1].Number)
+	}
+	if story.Iterations[0].Votes["Ala"] != 3 {
+		t.Errorf("zarchiwizowane głosy nie mogą się zmieniać, otrzymano %d", story.Iterations[0].Votes["Ala"])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a session event delivered to integrations such as webhooks. Unlike
+// the WebSocket messages it carries the data needed by systems that do not
+// read the session afterwards.
+type Event struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	SessionID   string    `json:"sessionId"`
+	SessionName string    `json:"sessionName,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt"`
+	Data        any       `json:"data,omitempty"`
+}
+
+const (
+	// eventSessionStarted is sent together with the first round.started.
+	eventSessionStarted = "session.started"
+	eventRoundStarted   = "round.started"
+	eventStoryEstimated = "story.estimated"
+	eventSessionEnded   = "session.ended"
+)
+
+var eventTypes = []string{eventSessionStarted, eventRoundStarted, eventStoryEstimated, eventSessionEnded}
+
+func validEventType(eventType string) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	eventHandlersMu sync.RWMutex
+	eventHandlers   []func(Event)
+)
+
+// subscribeEvents registers a handler called for every published event.
+func subscribeEvents(handler func(Event)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+	eventHandlers = append(eventHandlers, handler)
+}
+
+// publishEvent hands the event to every handler in the background so that
+// slow integrations never delay the request that caused it.
+func publishEvent(session *Session, eventType string, data any) {
+	event := Event{
+		ID:          uuid.New().String(),
+		Type:        eventType,
+		SessionID:   session.ID,
+		SessionName: session.Name,
+		OccurredAt:  time.Now().UTC(),
+		Data:        data,
+	}
+
+	eventHandlersMu.RLock()
+	defer eventHandlersMu.RUnlock()
+	for _, handler := range eventHandlers {
+		go handler(event)
+	}
+}
+
+type roundStartedData struct {
+	RoundID string          `json:"roundId"`
+	Stories []eventStoryRef `json:"stories"`
+}
+
+type eventStoryRef struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	ExternalKey string `json:"externalKey,omitempty"`
+}
+
+type storyEstimatedData struct {
+	RoundID       string `json:"roundId"`
+	StoryID       string `json:"storyId"`
+	Title         string `json:"title"`
+	ExternalKey   string `json:"externalKey,omitempty"`
+	FinalEstimate int    `json:"finalEstimate"`
+	Note          string `json:"note,omitempty"`
+}
+
+func newRoundStartedData(round *Round) roundStartedData {
+	data := roundStartedData{RoundID: round.ID, Stories: []eventStoryRef{}}
+	for _, story := range round.Stories {
+		data.Stories = append(data.Stories, eventStoryRef{
+			ID:          story.ID,
+			Title:       story.Title,
+			ExternalKey: story.ExternalKey,
+		})
+	}
+	return data
+}
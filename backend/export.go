@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// utf8BOM makes spreadsheet programs read the CSV file as UTF-8, so Polish
+// characters are not garbled.
+const utf8BOM = "\xef\xbb\xbf"
+
+// sessionResults are the estimation results of a session, one row per story.
+type sessionResults struct {
+	SessionID string             `json:"sessionId"`
+	Name      string             `json:"name"`
+	Players   []string           `json:"players"`
+	Stories   []*storyResultsRow `json:"stories"`
+}
+
+type storyResultsRow struct {
+	RoundID       string         `json:"roundId"`
+	StoryID       string         `json:"storyId"`
+	Title         string         `json:"title"`
+	Description   string         `json:"description,omitempty"`
+	Status        string         `json:"status"`
+	FinalEstimate *int           `json:"finalEstimate,omitempty"`
+	EstimateNote  string         `json:"estimateNote,omitempty"`
+	Votes         map[string]int `json:"votes"`
+	Stats         voteStatistics `json:"stats"`
+	Subtasks      []*Subtask     `json:"subtasks"`
+	SubtaskHours  float64        `json:"subtaskHours"`
+}
+
+type voteStatistics struct {
+	Count  int      `json:"count"`
+	Min    *int     `json:"min,omitempty"`
+	Max    *int     `json:"max,omitempty"`
+	Mean   *float64 `json:"mean,omitempty"`
+	Median *float64 `json:"median,omitempty"`
+}
+
+func computeVoteStatistics(votes map[string]int) voteStatistics {
+	stats := voteStatistics{Count: len(votes)}
+	if len(votes) == 0 {
+		return stats
+	}
+
+	values := make([]int, 0, len(votes))
+	sum := 0
+	for _, v := range votes {
+		values = append(values, v)
+		sum += v
+	}
+	sort.Ints(values)
+
+	low, high := values[0], values[len(values)-1]
+	mean := float64(sum) / float64(len(values))
+	median := float64(values[len(values)/2])
+	if len(values)%2 == 0 {
+		median = float64(values[len(values)/2-1]+values[len(values)/2]) / 2
+	}
+
+	stats.Min, stats.Max, stats.Mean, stats.Median = &low, &high, &mean, &median
+	return stats
+}
+
+// buildSessionResults collects the stories of every round. A story carried over
+// between rounds is listed once, with its latest state and the latest votes
+// cast for it.
+func buildSessionResults(session *Session) *sessionResults {
+	results := &sessionResults{
+		SessionID: session.ID,
+		Name:      session.Name,
+		Players:   []string{},
+		Stories:   []*storyResultsRow{},
+	}
+
+	rounds := append([]*Round{}, session.RoundHistory...)
+	if session.CurrentRound != nil {
+		rounds = append(rounds, session.CurrentRound)
+	}
+
+	rows := make(map[string]*storyResultsRow)
+	for _, round := range rounds {
+		for _, story := range round.Stories {
+			row, ok := rows[story.ID]
+			if !ok {
+				row = &storyResultsRow{Votes: map[string]int{}}
+				rows[story.ID] = row
+				results.Stories = append(results.Stories, row)
+			}
+
+			row.RoundID = round.ID
+			row.StoryID = story.ID
+			row.Title = story.Title
+			row.Description = story.Description
+			row.Status = story.status()
+			row.FinalEstimate = story.FinalEstimate
+			row.EstimateNote = story.EstimateNote
+			row.Subtasks = story.Subtasks
+			row.SubtaskHours = story.subtaskHours()
+			if votes := round.Votes[story.ID]; len(votes) > 0 {
+				row.Votes = votes
+			}
+		}
+	}
+
+	// Players who left the session still have their votes listed.
+	seen := make(map[string]bool)
+	addPlayer := func(player string) {
+		if !seen[player] {
+			seen[player] = true
+			results.Players = append(results.Players, player)
+		}
+	}
+	for _, player := range session.Players {
+		addPlayer(player)
+	}
+	var others []string
+	for _, row := range results.Stories {
+		row.Stats = computeVoteStatistics(row.Votes)
+		if row.Subtasks == nil {
+			row.Subtasks = []*Subtask{}
+		}
+		for player := range row.Votes {
+			if !seen[player] {
+				others = append(others, player)
+				seen[player] = true
+			}
+		}
+	}
+	sort.Strings(others)
+	results.Players = append(results.Players, others...)
+
+	return results
+}
+
+func encodeSessionResultsCSV(results *sessionResults) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(utf8BOM)
+
+	writer := csv.NewWriter(&buf)
+	header := []string{
+		"Runda", "ID", "Tytuł", "Opis", "Status", "Estymata", "Uzasadnienie",
+		"Liczba głosów", "Min", "Max", "Średnia", "Mediana", "Godziny zadań", "Zadania",
+	}
+	for _, player := range results.Players {
+		header = append(header, csvText(player))
+	}
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, row := range results.Stories {
+		record := []string{
+			row.RoundID,
+			row.StoryID,
+			csvText(row.Title),
+			csvText(row.Description),
+			row.Status,
+			formatOptionalInt(row.FinalEstimate),
+			csvText(row.EstimateNote),
+			strconv.Itoa(row.Stats.Count),
+			formatOptionalInt(row.Stats.Min),
+			formatOptionalInt(row.Stats.Max),
+			formatOptionalFloat(row.Stats.Mean),
+			formatOptionalFloat(row.Stats.Median),
+			strconv.FormatFloat(row.SubtaskHours, 'f', -1, 64),
+			csvText(formatSubtasks(row.Subtasks)),
+		}
+		for _, player := range results.Players {
+			value, ok := row.Votes[player]
+			if ok {
+				record = append(record, strconv.Itoa(value))
+			} else {
+				record = append(record, "")
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// csvText keeps spreadsheets from running text typed by users as a formula.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatOptionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func formatOptionalFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 2, 64)
+}
+
+// formatSubtasks lists the subtasks in a single cell, one per line.
+func formatSubtasks(subtasks []*Subtask) string {
+	lines := make([]string, 0, len(subtasks))
+	for _, subtask := range subtasks {
+		done := "[ ]"
+		if subtask.Done {
+			done = "[x]"
+		}
+		line := done + " " + subtask.Text
+		var details []string
+		if subtask.Assignee != "" {
+			details = append(details, subtask.Assignee)
+		}
+		if subtask.Hours != nil {
+			details = append(details, strconv.FormatFloat(*subtask.Hours, 'f', -1, 64)+"h")
+		}
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func exportSessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "Nieobsługiwany format eksportu", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	session.hideVoters()
+	results := buildSessionResults(session)
+
+	var data []byte
+	if format == "csv" {
+		data, err = encodeSessionResultsCSV(results)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		data, err = json.MarshalIndent(results, "", "  ")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	if err != nil {
+		http.Error(w, "Błąd przy eksporcie wyników", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sessionID+"."+format))
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func resultsSession() *Session {
+	eight := 8
+	hours := 2.5
+	story := &Story{ID: "s1", Title: `Logowanie "SSO", etap 1`, FinalEstimate: &eight, Subtasks: []*Subtask{
+		{ID: "t1", Text: "Żółta ścieżka", Assignee: "Łukasz", Hours: &hours, Done: true},
+	}}
+	return &Session{
+		ID:      "session-1",
+		Name:    "Sprint 7",
+		Players: []string{"Ala", "Łukasz"},
+		RoundHistory: []*Round{{
+			ID:      "round-1",
+			Votes:   map[string]map[string]int{"s1": {"Ala": 5, "Łukasz": 8}, "s2": {"Ala": 3}},
+			Stories: []*Story{story, {ID: "s2", Title: "Wylogowanie"}},
+		}},
+		CurrentRound: &Round{
+			ID:      "round-2",
+			Votes:   map[string]map[string]int{"s2": {"Ala": 2, "Łukasz": 3, "Zenon": 3}},
+			Stories: []*Story{{ID: "s2", Title: "Wylogowanie"}},
+		},
+	}
+}
+
+func TestBuildSessionResultsListsEachStoryOnce(t *testing.T) {
+	results := buildSessionResults(resultsSession())
+
+	if len(results.Stories) != 2 {
+		t.Fatalf("oczekiwano 2 wierszy, otrzymano %d", len(results.Stories))
+	}
+	carried := results.Stories[1]
+	if carried.RoundID != "round-2" || carried.Stats.Count != 3 {
+		t.Errorf("przeniesiona user story powinna mieć najnowsze głosy: %+v", carried)
+	}
+	if *carried.Stats.Median != 3 {
+		t.Errorf("oczekiwano mediany 3, otrzymano %v", *carried.Stats.Median)
+	}
+	if got := strings.Join(results.Players, ","); got != "Ala,Łukasz,Zenon" {
+		t.Errorf("nieoczekiwana lista graczy: %s", got)
+	}
+}
+
+func TestEncodeSessionResultsCSV(t *testing.T) {
+	data, err := encodeSessionResultsCSV(buildSessionResults(resultsSession()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(utf8BOM)) {
+		t.Error("plik CSV powinien zaczynać się od BOM UTF-8")
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data[len(utf8BOM):])).ReadAll()
+	if err != nil {
+		t.Fatalf("niepoprawny CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("oczekiwano nagłówka i 2 wierszy, otrzymano %d", len(records))
+	}
+
+	row := records[1]
+	if row[2] != `Logowanie "SSO", etap 1` {
+		t.Errorf("tytuł nie został poprawnie zapisany: %q", row[2])
+	}
+	if row[5] != "8" || row[13] != "[x] Żółta ścieżka (Łukasz, 2.5h)" {
+		t.Errorf("nieoczekiwany wiersz: %q", row)
+	}
+	if row[14] != "5" || row[15] != "8" || row[16] != "" {
+		t.Errorf("nieoczekiwane głosy: %q", row[14:])
+	}
+}
+
+func TestEncodeSessionResultsCSVEscapesFormulas(t *testing.T) {
+	results := &sessionResults{
+		Players: []string{"=Ala", "Jacek"},
+		Stories: []*storyResultsRow{{
+			RoundID:      "round-1",
+			StoryID:      "story-1",
+			Title:        "=HYPERLINK(\"https://evil\", \"Dołącz\")",
+			Description:  "@SUM(A1:A2)",
+			EstimateNote: "-1+1",
+			Subtasks:     []*Subtask{},
+			Votes:        map[string]int{"=Ala": 5},
+		}},
+	}
+
+	data, err := encodeSessionResultsCSV(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data[len(utf8BOM):])).ReadAll()
+	if err != nil {
+		t.Fatalf("niepoprawny CSV: %v", err)
+	}
+
+	if header := records[0]; header[14] != "'=Ala" || header[15] != "Jacek" {
+		t.Errorf("nazwy graczy nie zostały zabezpieczone: %q", header[14:])
+	}
+	row := records[1]
+	if row[2] != "'=HYPERLINK(\"https://evil\", \"Dołącz\")" || row[3] != "'@SUM(A1:A2)" || row[6] != "'-1+1" {
+		t.Errorf("formuły nie zostały zabezpieczone: %q", row)
+	}
+	if row[14] != "5" {
+		t.Errorf("głosy nie powinny być zmieniane: %q", row[14])
+	}
+}
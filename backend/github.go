@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// githubTracker uses GitHub Issues. GitHub has no story points field, so the
+// estimate is kept in a "points: N" label.
+type githubTracker struct {
+	config TrackerConfig
+	client *http.Client
+}
+
+const githubPointsLabelPrefix = "points: "
+
+type githubIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	Labels  []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	// PullRequest is set when the search returns a pull request.
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+func (t *githubTracker) authorize(req *http.Request) {
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if t.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.config.Token)
+	}
+}
+
+func (t *githubTracker) url(path string) string {
+	return strings.TrimRight(t.config.BaseURL, "/") + path
+}
+
+func (t *githubTracker) issueURL(number int, suffix string) string {
+	return t.url(fmt.Sprintf("/repos/%s/%s/issues/%d%s",
+		url.PathEscape(t.config.Owner), url.PathEscape(t.config.Repo), number, suffix))
+}
+
+// repository is the "owner/repo" name of the configured repository.
+func (t *githubTracker) repository() string {
+	return t.config.Owner + "/" + t.config.Repo
+}
+
+// issueNumber accepts "12" and "#12" as well as keys of the form
+// "owner/repo#12". Issue numbers repeat across repositories, so a key of
+// another repository is refused instead of being read as an issue of this one.
+func (t *githubTracker) issueNumber(key string) (int, error) {
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		if repo := key[:i]; repo != "" && !strings.EqualFold(repo, t.repository()) {
+			return 0, fmt.Errorf("zgłoszenie %q nie pochodzi z repozytorium %s", key, t.repository())
+		}
+		key = key[i+1:]
+	}
+	number, err := strconv.Atoi(key)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("nieprawidłowy numer zgłoszenia GitHub: %q", key)
+	}
+	return number, nil
+}
+
+// issue keys the issue like the GitHub webhooks do, so that an issue imported
+// by hand and sent by a webhook is recognized as the same one.
+func (t *githubTracker) issue(raw githubIssue) Issue {
+	return Issue{
+		Key:         fmt.Sprintf("%s#%d", t.repository(), raw.Number),
+		Title:       raw.Title,
+		Description: raw.Body,
+		URL:         raw.HTMLURL,
+	}
+}
+
+func (t *githubTracker) SearchIssues(ctx context.Context, query string) ([]Issue, error) {
+	q := fmt.Sprintf("repo:%s/%s is:issue is:open %s", t.config.Owner, t.config.Repo, strings.TrimSpace(query))
+	params := url.Values{}
+	params.Set("q", strings.TrimSpace(q))
+	params.Set("per_page", "50")
+
+	var result struct {
+		Items []githubIssue `json:"items"`
+	}
+	if err := doTrackerRequest(ctx, t.client, http.MethodGet, t.url("/search/issues?"+params.Encode()), t.authorize, nil, &result); err != nil {
+		return nil, err
+	}
+
+	issues := make([]Issue, 0, len(result.Items))
+	for _, raw := range result.Items {
+		if raw.PullRequest == nil {
+			issues = append(issues, t.issue(raw))
+		}
+	}
+	return issues, nil
+}
+
+func (t *githubTracker) getIssue(ctx context.Context, key string) (int, *githubIssue, error) {
+	number, err := t.issueNumber(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	var raw githubIssue
+	if err := doTrackerRequest(ctx, t.client, http.MethodGet, t.issueURL(number, ""), t.authorize, nil, &raw); err != nil {
+		return 0, nil, err
+	}
+	return number, &raw, nil
+}
+
+func (t *githubTracker) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	_, raw, err := t.getIssue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	issue := t.issue(*raw)
+	return &issue, nil
+}
+
+// SetStoryPoints replaces any earlier points label of the issue.
+func (t *githubTracker) SetStoryPoints(ctx context.Context, key string, points int) error {
+	number, raw, err := t.getIssue(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	label := fmt.Sprintf("%s%d", githubPointsLabelPrefix, points)
+	for _, existing := range raw.Labels {
+		if existing.Name == label || !strings.HasPrefix(existing.Name, githubPointsLabelPrefix) {
+			continue
+		}
+		path := "/labels/" + url.PathEscape(existing.Name)
+		if err := doTrackerRequest(ctx, t.client, http.MethodDelete, t.issueURL(number, path), t.authorize, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	body := map[string][]string{"labels": {label}}
+	return doTrackerRequest(ctx, t.client, http.MethodPost, t.issueURL(number, "/labels"), t.authorize, body, nil)
+}
+
+func (t *githubTracker) AddComment(ctx context.Context, key, comment string) error {
+	number, err := t.issueNumber(key)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"body": comment}
+	return doTrackerRequest(ctx, t.client, http.MethodPost, t.issueURL(number, "/comments"), t.authorize, body, nil)
+}
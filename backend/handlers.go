@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,9 +22,8 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-var wsConnections = make(map[string][]*websocket.Conn)
-
 func registerRoutes(r *mux.Router) {
+	r.Use(sessionStatusMiddleware)
 	r.HandleFunc("/sessions", createSession).Methods("POST")
 	r.HandleFunc("/sessions/{id}", getSessionHandler).Methods("GET")
 	r.HandleFunc("/sessions/{id}/join", joinSession).Methods("POST")
@@ -35,16 +35,68 @@ func registerRoutes(r *mux.Router) {
 	r.HandleFunc("/sessions/{id}/rollback-vote", rollbackVote).Methods("POST")
 	r.HandleFunc("/sessions/{id}/round-started", isRoundStarted).Methods("GET")
 	r.HandleFunc("/sessions/{id}/reveal", revealResults).Methods("POST")
+	r.HandleFunc("/sessions/{id}/revote", revoteHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/close", closeSessionHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/reopen", reopenSessionHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/archive", archiveSessionHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/settings", updateSettingsHandler).Methods("PUT")
+	r.HandleFunc("/sessions/{id}/export", exportSessionHandler).Methods("GET")
+	r.HandleFunc("/sessions/{id}/report", sessionReportHandler).Methods("GET")
 	r.HandleFunc("/sessions/{id}/ws", sessionWebSocket).Methods("GET")
 	r.HandleFunc("/sessions/{id}/rounds/{roundId}", getRoundDetails).Methods("GET")
 	r.HandleFunc("/sessions/{id}/stories", addStoryHandler).Methods("POST")
-	r.HandleFunc("/sessions/{id}/stories/{index}", deleteStoryHandler).Methods("DELETE")
-	r.HandleFunc("/sessions/{id}/stories/{index}", addStoryTaskHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}", deleteStoryHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/stories/import", importStoriesHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}", addSubtaskHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/subtasks", addSubtaskHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/subtasks/{subtaskId}", updateSubtaskHandler).Methods("PATCH")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/subtasks/{subtaskId}", deleteSubtaskHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/subtasks/{subtaskId}/complete", completeSubtaskHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/order", reorderStoriesHandler).Methods("PUT")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}", updateStoryHandler).Methods("PATCH")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/activate", activateStoryHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/estimate", setEstimateHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/estimate", clearEstimateHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/sync", syncEstimateHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/team", setSessionTeamHandler).Methods("PUT")
+	r.HandleFunc("/sessions/{id}/tracker/issues", searchTrackerIssuesHandler).Methods("GET")
+	r.HandleFunc("/sessions/{id}/tracker/import", importTrackerIssuesHandler).Methods("POST")
+	r.HandleFunc("/teams", createTeamHandler).Methods("POST")
+	r.HandleFunc("/teams/{teamId}", getTeamHandler).Methods("GET")
+	r.HandleFunc("/teams/{teamId}/tracker", updateTeamTrackerHandler).Methods("PUT")
+	r.HandleFunc("/teams/{teamId}/inbound", updateInboundHandler).Methods("PUT")
+	r.HandleFunc("/hooks/{provider}/{teamId}", inboundWebhookHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/push", pushStoryHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/stories/{storyId}/justifications", addJustificationHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/backlog", addBacklogStoryHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/backlog/{storyId}", deleteBacklogStoryHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/backlog/{storyId}/pull", pullStoryHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/notifier", updateNotifierHandler).Methods("PUT")
+	r.HandleFunc("/sessions/{id}/notifier", deleteNotifierHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/webhooks", createSessionWebhookHandler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/webhooks", listSessionWebhooksHandler).Methods("GET")
+	r.HandleFunc("/sessions/{id}/webhooks/{webhookId}", deleteSessionWebhookHandler).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}/webhooks/{webhookId}/deliveries", sessionWebhookDeliveriesHandler).Methods("GET")
+	r.HandleFunc("/webhooks", createGlobalWebhookHandler).Methods("POST")
+	r.HandleFunc("/webhooks", listGlobalWebhooksHandler).Methods("GET")
+	r.HandleFunc("/webhooks/{webhookId}", deleteGlobalWebhookHandler).Methods("DELETE")
+	r.HandleFunc("/webhooks/{webhookId}/deliveries", globalWebhookDeliveriesHandler).Methods("GET")
 	r.HandleFunc("/register", registerHandler).Methods("POST")
 	r.HandleFunc("/login", loginHandler).Methods("POST")
 	r.HandleFunc("/logout", logoutHandler).Methods("POST")
 	r.HandleFunc("/avatars", GetAvatars).Methods("GET")
 	r.HandleFunc("/user/avatar", updateAvatarHandler).Methods("PUT")
+	r.HandleFunc("/user/password", changePasswordHandler).Methods("PUT")
+	r.HandleFunc("/user/username", changeUsernameHandler).Methods("PUT")
+	r.HandleFunc("/user", deleteAccountHandler).Methods("DELETE")
+	r.HandleFunc("/user/email", changeEmailHandler).Methods("PUT")
+	r.HandleFunc("/password/forgot", forgotPasswordHandler).Methods("POST")
+	r.HandleFunc("/password/reset", resetPasswordHandler).Methods("POST")
+	r.HandleFunc("/me/sessions", mySessionsHandler).Methods("GET")
+	r.HandleFunc("/user/chat-link", createChatLinkCodeHandler).Methods("POST")
+	r.HandleFunc("/integrations/slack/commands", slackCommandHandler).Methods("POST")
+	r.HandleFunc("/user/export", requestDataExportHandler).Methods("POST")
+	r.HandleFunc("/user/export/{jobId}", getDataExportHandler).Methods("GET")
 
 }
 func registerHandler(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +104,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Password string `json:"password"`
 		Avatar   string `json:"avatar"`
+		Email    string `json:"email"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -59,8 +112,14 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if payload.Username == "" || payload.Password == "" {
-		http.Error(w, "Nazwa użytkownika i hasło są wymagane", http.StatusBadRequest)
+	payload.Username = normalizeUsername(payload.Username)
+
+	violations := append(
+		policy.checkUsername(payload.Username),
+		policy.checkPassword(payload.Password, payload.Username)...,
+	)
+	if len(violations) > 0 {
+		writePolicyViolations(w, violations)
 		return
 	}
 
@@ -68,15 +127,21 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		payload.Avatar = "🎭"
 	}
 
+	if payload.Email != "" && !isValidEmail(payload.Email) {
+		http.Error(w, "Nieprawidłowy adres e-mail", http.StatusBadRequest)
+		return
+	}
+
 	user := &User{
 		ID:       uuid.New().String(),
 		Username: payload.Username,
 		Password: payload.Password,
 		Avatar:   payload.Avatar,
+		Email:    payload.Email,
 	}
 
 	if err := saveUser(user); err != nil {
-		if err.Error() == "użytkownik już istnieje" {
+		if errors.Is(err, errUserExists) {
 			http.Error(w, "Użytkownik już istnieje", http.StatusConflict)
 		} else {
 			http.Error(w, "Błąd podczas zapisywania użytkownika", http.StatusInternalServerError)
@@ -352,7 +417,10 @@ func getRoundDetails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	session.hideVoters()
+
 	if session.CurrentRound != nil && session.CurrentRound.ID == roundID {
+		session.CurrentRound.VoteStats = computeVoteStats(session.CurrentRound)
 		w.Header().Set("Content-Type", "application/json")
 		err = json.NewEncoder(w).Encode(session.CurrentRound)
 		if err != nil {
@@ -364,6 +432,7 @@ func getRoundDetails(w http.ResponseWriter, r *http.Request) {
 	if session.RoundHistory != nil {
 		for _, round := range session.RoundHistory {
 			if round.ID == roundID {
+				round.VoteStats = computeVoteStats(round)
 				w.Header().Set("Content-Type", "application/json")
 				err = json.NewEncoder(w).Encode(round)
 				if err != nil {
@@ -378,23 +447,24 @@ func getRoundDetails(w http.ResponseWriter, r *http.Request) {
 }
 
 func notifySessionParticipants(sessionID, message string) {
-	var connectionsToKeep []*websocket.Conn
-
 	log.Printf("Notifying session %s participants: %s", sessionID, message)
 
-	for _, conn := range wsConnections[sessionID] {
-		err := conn.WriteMessage(websocket.TextMessage, []byte(message))
-		if err != nil {
-			log.Printf("Error sending WebSocket message: %v", err)
-			conn.Close()
-		} else {
-			connectionsToKeep = append(connectionsToKeep, conn)
-		}
-	}
+	sent := sessionHub.post(sessionID, wsMessage{text: message}, nil)
 
-	wsConnections[sessionID] = connectionsToKeep
+	log.Printf("Notification sent to %d connections", sent)
+}
+
+// notifyPlayers sends the message only to the connections opened by the
+// given players.
+func notifyPlayers(sessionID string, players []string, message string) {
+	recipients := make(map[string]bool, len(players))
+	for _, player := range players {
+		recipients[player] = true
+	}
 
-	log.Printf("Notification sent to %d connections", len(connectionsToKeep))
+	sessionHub.post(sessionID, wsMessage{text: message}, func(client *wsClient) bool {
+		return recipients[client.player]
+	})
 }
 
 func sessionWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -406,30 +476,34 @@ func sessionWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wsConnections[sessionID] = append(wsConnections[sessionID], conn)
+	client := sessionHub.add(sessionID, conn, r.URL.Query().Get("player"))
 
 	go func() {
 		for {
 			_, _, err := conn.ReadMessage()
 			if err != nil {
-				removeConnection(sessionID, conn)
+				sessionHub.remove(sessionID, client)
 				break
 			}
 		}
 	}()
 }
 
-func removeConnection(sessionID string, conn *websocket.Conn) {
-	connections := wsConnections[sessionID]
-	for i, c := range connections {
-		if c == conn {
-			wsConnections[sessionID] = append(connections[:i], connections[i+1:]...)
-			break
-		}
-	}
-	if len(wsConnections[sessionID]) == 0 {
-		delete(wsConnections, sessionID)
+// prepareSession gives a new session its ID and empty lists.
+func prepareSession(session *Session, facilitatorID string) {
+	session.Players = []string{}
+	session.Backlog = []*Story{}
+	session.Members = []string{}
+	if facilitatorID != "" {
+		session.Members = append(session.Members, facilitatorID)
 	}
+	session.ID = fmt.Sprintf("session-%d", time.Now().UnixNano())
+	session.FacilitatorID = facilitatorID
+	session.Status = sessionOpen
+	session.CreatedAt = time.Now().UTC()
+	session.LastActivityAt = session.CreatedAt
+	session.ClosedAt = nil
+	session.ArchivedAt = nil
 }
 
 func createSession(w http.ResponseWriter, r *http.Request) {
@@ -438,8 +512,13 @@ func createSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Nieprawidłowe dane", http.StatusBadRequest)
 		return
 	}
-	session.Players = []string{}
-	session.ID = fmt.Sprintf("session-%d", time.Now().UnixNano())
+
+	// A logged in creator becomes the facilitator of the session.
+	facilitatorID := ""
+	if user, _, err := authenticate(r); err == nil {
+		facilitatorID = user.ID
+	}
+	prepareSession(&session, facilitatorID)
 
 	if err := saveSession(&session); err != nil {
 		http.Error(w, "Błąd przy zapisie sesji", http.StatusInternalServerError)
@@ -464,6 +543,7 @@ func getSessionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session)
 	if err != nil {
@@ -490,16 +570,18 @@ func joinSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lists := session.storyLists()
 	session.Players = append(session.Players, payload.PlayerName)
+	if user, _, err := authenticate(r); err == nil {
+		session.addMember(user.ID)
+	}
 
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Błąd przy aktualizacji sesji", http.StatusInternalServerError)
+	if !saveSessionChange(w, session, lists) {
 		return
 	}
-	for _, conn := range wsConnections[id] {
-		conn.WriteMessage(websocket.TextMessage, []byte("/player-joined"))
-	}
+	notifySessionParticipants(id, "/player-joined")
 
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session)
 	if err != nil {
@@ -509,16 +591,9 @@ func joinSession(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func startRound(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	session, err := getSession(id)
-	if err != nil {
-		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
-		return
-	}
-
+// startNextRound archives the current round of the session and starts the
+// next one, carrying over the stories that were not estimated.
+func startNextRound(session *Session) *Round {
 	roundNumber := 1
 	if session.CurrentRound != nil {
 		_, err := fmt.Sscanf(session.CurrentRound.ID, "round-%d", &roundNumber)
@@ -527,31 +602,61 @@ func startRound(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	round := &Round{
+		ID:          fmt.Sprintf("round-%d", roundNumber),
+		Votes:       make(map[string]map[string]int),
+		Stories:     []*Story{},
+		ActiveStory: "",
+		StartedAt:   time.Now().UTC(),
+		Anonymous:   session.Settings.AnonymousVoting,
+	}
+
 	if session.CurrentRound != nil {
 		if session.RoundHistory == nil {
 			session.RoundHistory = []*Round{}
 		}
-		session.RoundHistory = append(session.RoundHistory, session.CurrentRound)
+		session.archiveRound(session.CurrentRound)
+
+		// Stories the team did not agree on stay on the table; the archived
+		// round keeps them as they were when it ended. They are copied after
+		// archiving, so names the archived round hid stay hidden.
+		round.Stories = session.CurrentRound.unestimatedStories()
+		if len(round.Stories) > 0 {
+			round.setActiveStory(round.Stories[0].ID)
+		}
 	}
-	session.User_stories = []string{}
-	session.Tasks = map[int]string{}
+	session.CurrentRound = round
+	return round
+}
 
+// publishRoundStarted publishes the start of the round once it is saved. The
+// first round also starts the session.
+func publishRoundStarted(session *Session, round *Round) {
+	if len(session.RoundHistory) == 0 {
+		publishEvent(session, eventSessionStarted, nil)
+	}
+	publishEvent(session, eventRoundStarted, newRoundStartedData(round))
+}
 
-	round := &Round{
-		ID:          fmt.Sprintf("round-%d", roundNumber),
-		Votes:       make(map[string]int),
-		UserStories: []string{},
-		Tasks:       map[int]string{},
-		ActiveStory: 0,
+func startRound(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	session, err := getSession(id)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
 	}
-	session.CurrentRound = round
+
+	lists := session.storyLists()
+	round := startNextRound(session)
 
 	notifySessionParticipants(id, "/starting")
 
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Błąd przy aktualizacji rundy", http.StatusInternalServerError)
+	if !saveSessionChange(w, session, lists) {
 		return
 	}
+	publishRoundStarted(session, round)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(round); err != nil {
@@ -584,25 +689,27 @@ func vote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session.CurrentRound.Votes[payload.PlayerName] = payload.Vote
+	lists := session.storyLists()
+	session.CurrentRound.vote(payload.PlayerName, payload.Vote)
 
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Błąd przy aktualizacji głosów", http.StatusInternalServerError)
-		return
+	voter := payload.PlayerName
+	if session.CurrentRound.Anonymous {
+		voter = session.CurrentRound.pseudonym(voter)
 	}
 
-	message := fmt.Sprintf("/player-voted:%s", payload.PlayerName)
-	for _, conn := range wsConnections[id] {
-		conn.WriteMessage(websocket.TextMessage, []byte(message))
+	if !saveSessionChange(w, session, lists) {
+		return
 	}
 
-	if len(session.CurrentRound.Votes) == len(session.Players) {
+	message := fmt.Sprintf("/player-voted:%s", voter)
+	notifySessionParticipants(id, message)
+
+	if len(session.CurrentRound.activeVotes()) == len(session.Players) {
 		// If all have voted, notify to reveal
-		for _, conn := range wsConnections[id] {
-			conn.WriteMessage(websocket.TextMessage, []byte("/all-voted"))
-		}
+		notifySessionParticipants(id, "/all-voted")
 	}
 
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -626,6 +733,7 @@ func getResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -643,11 +751,16 @@ func revealResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Notify all players to reveal choices
-	for _, conn := range wsConnections[id] {
-		conn.WriteMessage(websocket.TextMessage, []byte("/reveals"))
+	lists := session.storyLists()
+	askOutliers(session)
+	if !saveSessionChange(w, session, lists) {
+		return
 	}
 
+	// Notify all players to reveal choices
+	notifySessionParticipants(id, "/reveals")
+
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -683,16 +796,14 @@ func removePlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lists := session.storyLists()
 	session.Players = updatedPlayers
 
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Błąd przy aktualizacji sesji", http.StatusInternalServerError)
+	if !saveSessionChange(w, session, lists) {
 		return
 	}
 
-	for _, conn := range wsConnections[sessionID] {
-		conn.WriteMessage(websocket.TextMessage, []byte("/player-left"))
-	}
+	notifySessionParticipants(sessionID, "/player-left")
 
 	w.WriteHeader(http.StatusNoContent)
 	return
@@ -722,19 +833,18 @@ func rollbackVote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, exists := session.CurrentRound.Votes[payload.PlayerName]; !exists {
+	lists := session.storyLists()
+	if !session.CurrentRound.retractVote(payload.PlayerName) {
 		http.Error(w, "Głos gracza nie istnieje", http.StatusNotFound)
 		return
 	}
 
-	delete(session.CurrentRound.Votes, payload.PlayerName)
-
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Błąd przy zapisie sesji", http.StatusInternalServerError)
+	if !saveSessionChange(w, session, lists) {
 		return
 	}
 
 	// w.WriteHeader(http.StatusNoContent)
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -780,13 +890,25 @@ func addStoryHandler(w http.ResponseWriter, r *http.Request) {
 	sessionID := vars["id"]
 
 	var payload struct {
-		Story string `json:"story"`
+		// Story is the title sent by older clients.
+		Story       string `json:"story"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Author      string `json:"author"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Błędne dane", http.StatusBadRequest)
 		return
 	}
 
+	if payload.Title == "" {
+		payload.Title = payload.Story
+	}
+	if payload.Title == "" {
+		http.Error(w, "Tytuł user story jest wymagany", http.StatusBadRequest)
+		return
+	}
+
 	session, err := getSession(sessionID)
 	if err != nil {
 		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
@@ -798,19 +920,40 @@ func addStoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session.CurrentRound.User_stories = append(session.CurrentRound.User_stories, payload.Story)
+	story := newStory(payload.Title, payload.Description, payload.Author)
+	if user, _, err := authenticate(r); err == nil {
+		story.Author = user.Username
+		story.AuthorID = user.ID
+	}
+
+	lists := session.storyLists()
+	round := session.CurrentRound
+	first := round.ActiveStory == ""
+	round.Stories = append(round.Stories, story)
+	round.StoriesVersion++
 
-	if err := saveSession(session); err != nil {
+	var saved bool
+	if first {
+		// Opening voting on the first story changes more than the list.
+		round.setActiveStory(story.ID)
+		saved, err = saveStoryLists(session, lists)
+	} else {
+		saved, err = appendRoundStory(sessionID, round.ID, story)
+	}
+	if err != nil {
 		http.Error(w, "Błąd przy dodawaniu user story", http.StatusInternalServerError)
 		return
 	}
-
-	message := fmt.Sprintf("/userstory-added:%s", payload.Story)
-	for _, conn := range wsConnections[sessionID] {
-		conn.WriteMessage(websocket.TextMessage, []byte(message))
+	if !saved {
+		http.Error(w, "Lista user stories została w międzyczasie zmieniona", http.StatusConflict)
+		return
 	}
 
+	message := fmt.Sprintf("/userstory-added:%s", story.ID)
+	notifySessionParticipants(sessionID, message)
+
 	notifySessionParticipants(sessionID, "/story-added")
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -822,14 +965,7 @@ func addStoryHandler(w http.ResponseWriter, r *http.Request) {
 func deleteStoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["id"]
-	indexStr := vars["index"]
-
-	var index int
-	_, err := fmt.Sscanf(indexStr, "%d", &index)
-	if err != nil {
-		http.Error(w, "Invalid index", http.StatusBadRequest)
-		return
-	}
+	storyID := vars["storyId"]
 
 	session, err := getSession(sessionID)
 	if err != nil {
@@ -842,31 +978,27 @@ func deleteStoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if index < 0 || index >= len(session.CurrentRound.User_stories) {
-		http.Error(w, "invalid story index", http.StatusNotFound)
+	lists := session.storyLists()
+	if session.CurrentRound.removeStory(storyID) == nil {
+		http.Error(w, "User story nie znaleziona", http.StatusNotFound)
 		return
 	}
 
-	session.CurrentRound.User_stories = append(
-		session.CurrentRound.User_stories[:index],
-		session.CurrentRound.User_stories[index+1:]...,
-	)
-
-	if session.CurrentRound.Tasks != nil {
-		delete(session.CurrentRound.Tasks, index)
-	}
-
-	if err := saveSession(session); err != nil {
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	message := fmt.Sprintf("/userstory-removed:%s", indexStr)
-	for _, conn := range wsConnections[sessionID] {
-		conn.WriteMessage(websocket.TextMessage, []byte(message))
+	if !saved {
+		http.Error(w, "Lista user stories została w międzyczasie zmieniona", http.StatusConflict)
+		return
 	}
 
+	message := fmt.Sprintf("/userstory-removed:%s", storyID)
+	notifySessionParticipants(sessionID, message)
+
 	notifySessionParticipants(sessionID, "/story-removed")
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
@@ -875,17 +1007,10 @@ func deleteStoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func addStoryTaskHandler(w http.ResponseWriter, r *http.Request) {
+func activateStoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["id"]
-	indexStr := vars["index"]
-
-	var index int
-	_, err := fmt.Sscanf(indexStr, "%d", &index)
-	if err != nil {
-		http.Error(w, "Invalid index", http.StatusBadRequest)
-		return
-	}
+	storyID := vars["storyId"]
 
 	session, err := getSession(sessionID)
 	if err != nil {
@@ -898,38 +1023,22 @@ func addStoryTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if index < 0 || index >= len(session.CurrentRound.User_stories) {
-		http.Error(w, "invalid story index", http.StatusNotFound)
+	if session.CurrentRound.findStory(storyID) == nil {
+		http.Error(w, "User story nie znaleziona", http.StatusNotFound)
 		return
 	}
 
-	var payload struct {
-		Task string `json:"task"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Błędne dane gracza", http.StatusBadRequest)
-		return
-	}
+	lists := session.storyLists()
+	session.CurrentRound.setActiveStory(storyID)
 
-	if session.CurrentRound.Tasks == nil {
-		session.CurrentRound.Tasks = make(map[int]string)
-	}
-
-	session.CurrentRound.Tasks[index] = payload.Task
-
-	if err := saveSession(session); err != nil {
-		http.Error(w, "Wystąpił błąd zapisu", http.StatusInternalServerError)
+	if !saveSessionChange(w, session, lists) {
 		return
 	}
 
-	message := fmt.Sprintf("/task-added:%s", indexStr)
-	for _, conn := range wsConnections[sessionID] {
-		conn.WriteMessage(websocket.TextMessage, []byte(message))
-	}
-
-	notifySessionParticipants(sessionID, "/task-added")
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-activated:%s", storyID))
+	session.hideVoters()
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(session.CurrentRound.Tasks)
+	err = json.NewEncoder(w).Encode(session.CurrentRound)
 	if err != nil {
 		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
 		return
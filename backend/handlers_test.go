@@ -2,13 +2,60 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
+// TestMain connects the tests that need MongoDB to the server in
+// MONGO_TEST_URI, using a fresh database dropped afterwards. Without it those
+// tests are skipped.
+func TestMain(m *testing.M) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		os.Exit(m.Run())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	testClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err == nil {
+		err = testClient.Ping(ctx, nil)
+	}
+	if err != nil {
+		log.Fatalf("Brak połączenia z bazą testową: %v", err)
+	}
+
+	db := testClient.Database(fmt.Sprintf("planning_test_%d", time.Now().UnixNano()))
+	useDatabase(db)
+	if err := ensureIndexes(ctx); err != nil {
+		log.Fatalf("Błąd przy tworzeniu indeksów: %v", err)
+	}
+	cancel()
+
+	code := m.Run()
+
+	db.Drop(context.Background())
+	testClient.Disconnect(context.Background())
+	os.Exit(code)
+}
+
+// requireMongo skips the test when no test database is configured.
+func requireMongo(t *testing.T) {
+	t.Helper()
+	if sessionCol == nil {
+		t.Skip("Brak bazy testowej, ustaw MONGO_TEST_URI")
+	}
+}
+
 func setupRouter() *mux.Router {
 	r := mux.NewRouter()
 	registerRoutes(r)
@@ -16,6 +63,7 @@ func setupRouter() *mux.Router {
 }
 
 func TestCreateSession(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -45,6 +93,7 @@ func TestCreateSession(t *testing.T) {
 }
 
 func TestJoinSession(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -85,6 +134,7 @@ func TestJoinSession(t *testing.T) {
 }
 
 func TestRemovePlayer(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -141,6 +191,7 @@ func TestRemovePlayer(t *testing.T) {
 
 
 func TestRollbackVote(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -202,12 +253,13 @@ func TestRollbackVote(t *testing.T) {
 	if err := json.Unmarshal(getResultsRr.Body.Bytes(), &round); err != nil {
 		t.Fatal(err)
 	}
-	if _, exists := round.Votes["Ala"]; exists {
+	if _, exists := round.Votes[noStoryVotes]["Ala"]; exists {
 		t.Errorf("Głos nie został usunięty: %v", round.Votes)
 	}
 }
 
 func TestIsRoundStarted(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -259,6 +311,7 @@ func TestIsRoundStarted(t *testing.T) {
 
 
 func TestVote(t *testing.T) {
+	requireMongo(t)
 	router := setupRouter()
 
 	sessionData := map[string]interface{}{
@@ -302,7 +355,7 @@ func TestVote(t *testing.T) {
 	if err := json.Unmarshal(voteRr.Body.Bytes(), &round); err != nil {
 		t.Fatal(err)
 	}
-	if v, ok := round.Votes["Jan"]; !ok || v != 5 {
+	if v, ok := round.Votes[noStoryVotes]["Jan"]; !ok || v != 5 {
 		t.Errorf(" otrzymano: %v", round.Votes)
 	}
 }
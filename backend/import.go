@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxImportSize    = 1 << 20
+	maxImportStories = 500
+)
+
+// importedStory is a story parsed from the import, shown in the preview.
+type importedStory struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ExternalKey string `json:"externalKey,omitempty"`
+	// DuplicateOf is the ID of the existing story with the same key or title,
+	// or "import" when the story is repeated within the import itself.
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+}
+
+// importMapping names the CSV columns holding the story fields. Names are
+// matched case-insensitively against the header row.
+type importMapping struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ExternalKey string `json:"externalKey"`
+}
+
+// issueKeyPattern matches a tracker key at the start of a line, e.g.
+// "KP-12: Logowanie" or "[KP-12] Logowanie".
+var issueKeyPattern = regexp.MustCompile(`^\[?([A-Z][A-Z0-9]+-\d+)\]?[:\s]\s*`)
+
+func splitIssueKey(line string) (string, string) {
+	match := issueKeyPattern.FindStringSubmatch(line)
+	if match == nil {
+		return "", line
+	}
+	return match[1], strings.TrimSpace(line[len(match[0]):])
+}
+
+func parseImport(format, content string, mapping importMapping) ([]*importedStory, error) {
+	content = strings.TrimPrefix(content, utf8BOM)
+
+	switch format {
+	case "csv":
+		return parseCSVImport(content, mapping)
+	case "markdown", "md":
+		return parseMarkdownImport(content), nil
+	case "text", "":
+		return parseTextImport(content), nil
+	}
+	return nil, fmt.Errorf("nieobsługiwany format importu: %s", format)
+}
+
+func parseCSVImport(content string, mapping importMapping) ([]*importedStory, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	// Spreadsheets set to Polish locale separate columns with semicolons.
+	firstLine, _, _ := strings.Cut(content, "\n")
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("niepoprawny plik CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("plik CSV jest pusty")
+	}
+
+	if mapping.Title == "" {
+		mapping.Title = "title"
+	}
+	if mapping.Description == "" {
+		mapping.Description = "description"
+	}
+	if mapping.ExternalKey == "" {
+		mapping.ExternalKey = "key"
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) int {
+		if i, ok := columns[strings.ToLower(name)]; ok {
+			return i
+		}
+		return -1
+	}
+
+	titleColumn := column(mapping.Title)
+	if titleColumn < 0 {
+		return nil, fmt.Errorf("brak kolumny %q z tytułem user story", mapping.Title)
+	}
+	descriptionColumn := column(mapping.Description)
+	keyColumn := column(mapping.ExternalKey)
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	stories := []*importedStory{}
+	for _, record := range records[1:] {
+		title := field(record, titleColumn)
+		if title == "" {
+			continue
+		}
+		stories = append(stories, &importedStory{
+			Title:       title,
+			Description: field(record, descriptionColumn),
+			ExternalKey: field(record, keyColumn),
+		})
+	}
+	return stories, nil
+}
+
+var (
+	markdownItemPattern    = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+(?:\[[ xX]\]\s+)?`)
+	markdownHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+`)
+)
+
+// parseMarkdownImport reads every top-level bullet or numbered item as a
+// story. Headings are section headers, except in a list made of headings only,
+// where the headings below the first level are the stories. Indented lines and
+// paragraphs below a story become its description.
+func parseMarkdownImport(content string) []*importedStory {
+	lines := strings.Split(content, "\n")
+	headingStories := true
+	for _, line := range lines {
+		if markdownItemPattern.MatchString(line) {
+			headingStories = false
+			break
+		}
+	}
+
+	stories := []*importedStory{}
+	var current *importedStory
+	var description []string
+
+	flush := func() {
+		if current != nil {
+			current.Description = strings.TrimSpace(strings.Join(description, "\n"))
+		}
+		current = nil
+		description = nil
+	}
+	add := func(title string) {
+		key, title := splitIssueKey(strings.TrimSpace(title))
+		current = &importedStory{Title: strings.Trim(title, "*_ "), ExternalKey: key}
+		stories = append(stories, current)
+	}
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r \t")
+		if line == "" {
+			continue
+		}
+
+		if heading := markdownHeadingPattern.FindStringSubmatch(line); heading != nil {
+			flush()
+			if headingStories && len(heading[1]) > 1 {
+				add(line[len(heading[0]):])
+			}
+			continue
+		}
+
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		if item := markdownItemPattern.FindString(line); item != "" && !indented {
+			flush()
+			add(line[len(item):])
+			continue
+		}
+
+		if current != nil {
+			description = append(description, strings.TrimSpace(line))
+		}
+	}
+	flush()
+
+	return stories
+}
+
+func parseTextImport(content string) []*importedStory {
+	stories := []*importedStory{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, title := splitIssueKey(line)
+		stories = append(stories, &importedStory{Title: title, ExternalKey: key})
+	}
+	return stories
+}
+
+// storyMatchKey is used to find duplicates: the tracker key when there is
+// one, otherwise the title ignoring case and spacing.
+func storyMatchKey(title, externalKey string) string {
+	if externalKey != "" {
+		return "key:" + strings.ToLower(externalKey)
+	}
+	return "title:" + strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// markDuplicates flags imported stories already present in the session or
+// repeated earlier in the import.
+func markDuplicates(session *Session, stories []*importedStory) {
+	existing := make(map[string]string)
+	add := func(story *Story) {
+		existing[storyMatchKey(story.Title, "")] = story.ID
+		if story.ExternalKey != "" {
+			existing[storyMatchKey("", story.ExternalKey)] = story.ID
+		}
+	}
+
+	for _, story := range session.Backlog {
+		add(story)
+	}
+	rounds := append([]*Round{}, session.RoundHistory...)
+	if session.CurrentRound != nil {
+		rounds = append(rounds, session.CurrentRound)
+	}
+	for _, round := range rounds {
+		for _, story := range round.Stories {
+			add(story)
+		}
+	}
+
+	for _, story := range stories {
+		key := storyMatchKey(story.Title, story.ExternalKey)
+		if id, ok := existing[key]; ok {
+			story.DuplicateOf = id
+			continue
+		}
+		existing[key] = "import"
+	}
+}
+
+// addImportedStories adds the stories that are not duplicates to the backlog
+// or to the current round and returns how many were added.
+func addImportedStories(session *Session, stories []*importedStory, target, author, authorID string) int {
+	imported := 0
+	for _, parsed := range stories {
+		if parsed.DuplicateOf != "" {
+			continue
+		}
+		story := newStory(parsed.Title, parsed.Description, author)
+		story.AuthorID = authorID
+		story.ExternalKey = parsed.ExternalKey
+
+		if target == "round" {
+			round := session.CurrentRound
+			round.Stories = append(round.Stories, story)
+			if round.ActiveStory == "" {
+				round.setActiveStory(story.ID)
+			}
+		} else {
+			session.Backlog = append(session.Backlog, story)
+		}
+		imported++
+	}
+
+	if imported > 0 && target == "round" {
+		session.CurrentRound.StoriesVersion++
+	} else if imported > 0 {
+		session.BacklogVersion++
+	}
+	return imported
+}
+
+func importStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	var payload struct {
+		Format  string        `json:"format"`
+		Content string        `json:"content"`
+		Mapping importMapping `json:"mapping"`
+		// Target is "backlog" (the default) or "round".
+		Target string `json:"target"`
+		// Preview only parses the stories without saving them.
+		Preview bool `json:"preview"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Target == "" {
+		payload.Target = "backlog"
+	}
+	if payload.Target != "backlog" && payload.Target != "round" {
+		http.Error(w, "Nieznane miejsce importu", http.StatusBadRequest)
+		return
+	}
+
+	stories, err := parseImport(payload.Format, payload.Content, payload.Mapping)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(stories) == 0 {
+		http.Error(w, "Nie znaleziono żadnych user stories", http.StatusBadRequest)
+		return
+	}
+	if len(stories) > maxImportStories {
+		http.Error(w, fmt.Sprintf("Można zaimportować najwyżej %d user stories naraz", maxImportStories), http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if payload.Target == "round" && session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return
+	}
+
+	markDuplicates(session, stories)
+
+	duplicates := 0
+	for _, parsed := range stories {
+		if parsed.DuplicateOf != "" {
+			duplicates++
+		}
+	}
+
+	imported := 0
+	if !payload.Preview {
+		author, authorID := "", ""
+		if user, _, err := authenticate(r); err == nil {
+			author, authorID = user.Username, user.ID
+		}
+
+		lists := session.storyLists()
+		imported = addImportedStories(session, stories, payload.Target, author, authorID)
+
+		if imported > 0 {
+			saved, err := saveStoryLists(session, lists)
+			if err != nil {
+				http.Error(w, "Błąd przy imporcie user stories", http.StatusInternalServerError)
+				return
+			}
+			if !saved {
+				http.Error(w, "Backlog lub lista user stories zostały w międzyczasie zmienione", http.StatusConflict)
+				return
+			}
+			notifySessionParticipants(sessionID, fmt.Sprintf("/stories-imported:%d", imported))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Preview  bool             `json:"preview"`
+		Stories  []*importedStory `json:"stories"`
+		Imported int              `json:"imported"`
+		// Duplicates are never imported.
+		Duplicates int `json:"duplicates"`
+	}{
+		Preview:    payload.Preview,
+		Stories:    stories,
+		Imported:   imported,
+		Duplicates: duplicates,
+	})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseCSVImport(t *testing.T) {
+	content := utf8BOM + "Klucz;Podsumowanie;Opis\n" +
+		"KP-1;Logowanie;\"Przez SSO; także Google\"\n" +
+		"KP-2;\"Eksport \"\"CSV\"\"\";\n" +
+		";;bez tytułu\n"
+
+	stories, err := parseImport("csv", content, importMapping{Title: "podsumowanie", Description: "opis", ExternalKey: "klucz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*importedStory{
+		{Title: "Logowanie", Description: "Przez SSO; także Google", ExternalKey: "KP-1"},
+		{Title: `Eksport "CSV"`, ExternalKey: "KP-2"},
+	}
+	if !reflect.DeepEqual(stories, want) {
+		t.Errorf("oczekiwano %+v, otrzymano %+v", want, stories)
+	}
+
+	if _, err := parseImport("csv", "a,b\n1,2\n", importMapping{}); err == nil {
+		t.Error("brak kolumny z tytułem powinien zwrócić błąd")
+	}
+}
+
+func TestParseMarkdownImport(t *testing.T) {
+	content := "# Sprint 7\n\n" +
+		"- KP-3: Logowanie\n" +
+		"  Przez SSO\n" +
+		"  - szczegół\n" +
+		"* [ ] **Wylogowanie**\n" +
+		"1. Reset hasła\n"
+
+	stories := parseMarkdownImport(content)
+	titles := []string{}
+	for _, story := range stories {
+		titles = append(titles, story.Title)
+	}
+	if !reflect.DeepEqual(titles, []string{"Logowanie", "Wylogowanie", "Reset hasła"}) {
+		t.Fatalf("nieoczekiwane tytuły: %q", titles)
+	}
+	if stories[0].ExternalKey != "KP-3" || stories[0].Description != "Przez SSO\n- szczegół" {
+		t.Errorf("nieoczekiwana user story: %+v", stories[0])
+	}
+}
+
+func TestParseMarkdownImportSections(t *testing.T) {
+	content := "# Sprint 7\n\n" +
+		"## Backend\n" +
+		"- Logowanie\n" +
+		"  Przez SSO\n" +
+		"## Frontend\n" +
+		"Uwagi do sekcji\n" +
+		"- Wylogowanie\n"
+
+	stories := parseMarkdownImport(content)
+	if len(stories) != 2 || stories[0].Title != "Logowanie" || stories[1].Title != "Wylogowanie" {
+		t.Fatalf("nagłówki sekcji nie powinny być user stories: %+v", stories)
+	}
+	if stories[0].Description != "Przez SSO" || stories[1].Description != "" {
+		t.Errorf("opis nie powinien obejmować kolejnej sekcji: %q, %q", stories[0].Description, stories[1].Description)
+	}
+
+	headings := "# Sprint 7\n## KP-3: Logowanie\nPrzez SSO\n### Wylogowanie\n"
+	stories = parseMarkdownImport(headings)
+	if len(stories) != 2 || stories[0].Title != "Logowanie" || stories[0].ExternalKey != "KP-3" || stories[1].Title != "Wylogowanie" {
+		t.Fatalf("nieoczekiwane user stories z nagłówków: %+v", stories)
+	}
+	if stories[0].Description != "Przez SSO" {
+		t.Errorf("nieoczekiwany opis: %q", stories[0].Description)
+	}
+}
+
+func TestMarkDuplicates(t *testing.T) {
+	existing := &Story{ID: "s1", Title: "Logowanie  przez SSO", ExternalKey: "KP-1"}
+	session := &Session{Backlog: []*Story{existing}}
+
+	stories := parseTextImport("logowanie przez sso\nKP-1 Inny tytuł\nWylogowanie\nwylogowanie\n")
+	markDuplicates(session, stories)
+
+	got := []string{}
+	for _, story := range stories {
+		got = append(got, story.DuplicateOf)
+	}
+	if !reflect.DeepEqual(got, []string{"s1", "s1", "", "import"}) {
+		t.Errorf("nieoczekiwane duplikaty: %q", got)
+	}
+}
+
+func TestImportRouteIsNotAStoryID(t *testing.T) {
+	router := mux.NewRouter()
+	registerRoutes(router)
+
+	var match mux.RouteMatch
+	req := httptest.NewRequest("POST", "/sessions/session-1/stories/import", nil)
+	if !router.Match(req, &match) || match.Vars["storyId"] != "" {
+		t.Errorf("import trafił do niewłaściwej ścieżki: %v", match.Vars)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultInboundLabel = "needs-estimate"
+	maxInboundBodySize  = 1 << 20
+	// inboundEventTTL is how long delivery IDs are remembered to ignore
+	// redelivered events.
+	inboundEventTTL = 7 * 24 * time.Hour
+)
+
+// InboundConfig lets the tracker of a team add issues to the backlog of its
+// sessions through webhooks.
+type InboundConfig struct {
+	// Secret verifies the signatures of the incoming webhooks. It is shown
+	// only once, when generated.
+	Secret string         `json:"-"`
+	Rules  []*InboundRule `json:"rules"`
+}
+
+// InboundRule sends the matching issues to the backlog of a session. Empty
+// fields match any issue; the first matching rule wins.
+type InboundRule struct {
+	// Label is an issue label, "needs-estimate" by default.
+	Label string `json:"label"`
+	// Project is the Jira project key or the GitHub repository "owner/repo".
+	Project   string `json:"project,omitempty"`
+	SessionID string `json:"sessionId"`
+}
+
+// inboundIssue is an issue from the webhook of any provider.
+type inboundIssue struct {
+	Key         string
+	Title       string
+	Description string
+	Project     string
+	Labels      []string
+}
+
+func (rule *InboundRule) matches(issue *inboundIssue) bool {
+	if rule.Project != "" && !strings.EqualFold(rule.Project, issue.Project) {
+		return false
+	}
+	if rule.Label == "" {
+		return true
+	}
+	for _, label := range issue.Labels {
+		if strings.EqualFold(label, rule.Label) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *InboundConfig) match(issue *inboundIssue) *InboundRule {
+	for _, rule := range c.Rules {
+		if rule.matches(issue) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// inboundProvider reads the webhooks of one tracker.
+type inboundProvider struct {
+	// signatureHeader holds "sha256=" and the hex HMAC of the body.
+	signatureHeader string
+	// deliveryHeader identifies the delivery, also when it is redelivered.
+	deliveryHeader string
+	// parse returns nil for events that do not concern issues.
+	parse func(r *http.Request, body []byte) (*inboundIssue, error)
+}
+
+var inboundProviders = map[string]inboundProvider{
+	trackerGitHub: {
+		signatureHeader: "X-Hub-Signature-256",
+		deliveryHeader:  "X-GitHub-Delivery",
+		parse:           parseGitHubWebhook,
+	},
+	trackerJira: {
+		signatureHeader: "X-Hub-Signature",
+		deliveryHeader:  "X-Atlassian-Webhook-Identifier",
+		parse:           parseJiraWebhook,
+	},
+}
+
+func verifyInboundSignature(secret, signature string, body []byte) bool {
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+func parseGitHubWebhook(r *http.Request, body []byte) (*inboundIssue, error) {
+	if r.Header.Get("X-GitHub-Event") != "issues" {
+		return nil, nil
+	}
+
+	var payload struct {
+		Action     string      `json:"action"`
+		Issue      githubIssue `json:"issue"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	switch payload.Action {
+	case "opened", "reopened", "labeled":
+	default:
+		return nil, nil
+	}
+
+	issue := &inboundIssue{
+		// Issue numbers repeat across repositories.
+		Key:         fmt.Sprintf("%s#%d", payload.Repository.FullName, payload.Issue.Number),
+		Title:       payload.Issue.Title,
+		Description: payload.Issue.Body,
+		Project:     payload.Repository.FullName,
+	}
+	for _, label := range payload.Issue.Labels {
+		issue.Labels = append(issue.Labels, label.Name)
+	}
+	return issue, nil
+}
+
+func parseJiraWebhook(r *http.Request, body []byte) (*inboundIssue, error) {
+	var payload struct {
+		WebhookEvent string `json:"webhookEvent"`
+		Issue        struct {
+			Key    string `json:"key"`
+			Fields struct {
+				Summary     string   `json:"summary"`
+				Description string   `json:"description"`
+				Labels      []string `json:"labels"`
+				Project     struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"fields"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.WebhookEvent != "jira:issue_created" && payload.WebhookEvent != "jira:issue_updated" {
+		return nil, nil
+	}
+
+	return &inboundIssue{
+		Key:         payload.Issue.Key,
+		Title:       payload.Issue.Fields.Summary,
+		Description: payload.Issue.Fields.Description,
+		Project:     payload.Issue.Fields.Project.Key,
+		Labels:      payload.Issue.Fields.Labels,
+	}, nil
+}
+
+// addInboundIssue adds the issue to the session backlog unless the session
+// already has it. It returns nil for duplicates.
+func addInboundIssue(session *Session, issue *inboundIssue) *Story {
+	parsed := []*importedStory{{Title: issue.Title, Description: issue.Description, ExternalKey: issue.Key}}
+	markDuplicates(session, parsed)
+	if addImportedStories(session, parsed, "backlog", "", "") == 0 {
+		return nil
+	}
+	return session.Backlog[len(session.Backlog)-1]
+}
+
+// notContainingIssue matches sessions that do not have a story with the key
+// yet, neither in the backlog nor in the current round.
+func notContainingIssue(key string) bson.M {
+	if key == "" {
+		return nil
+	}
+	return bson.M{
+		"backlog.externalkey":              bson.M{"$ne": key},
+		"currentround.stories.externalkey": bson.M{"$ne": key},
+	}
+}
+
+type inboundResult struct {
+	// Status is "added", "duplicate" or "ignored".
+	Status  string `json:"status"`
+	StoryID string `json:"storyId,omitempty"`
+}
+
+func writeInboundResult(w http.ResponseWriter, status int, result inboundResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// inboundWebhookHandler receives the issue webhooks of a team tracker. It
+// answers 2xx also for ignored events so that the tracker does not retry them.
+func inboundWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	provider, ok := inboundProviders[vars["provider"]]
+	if !ok {
+		http.Error(w, "Nieobsługiwany tracker", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboundBodySize))
+	if err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	team, err := getTeam(vars["teamId"])
+	if err != nil || team.Inbound == nil || team.Inbound.Secret == "" {
+		http.Error(w, "Zespół nie znaleziony", http.StatusNotFound)
+		return
+	}
+
+	if !verifyInboundSignature(team.Inbound.Secret, r.Header.Get(provider.signatureHeader), body) {
+		http.Error(w, "Nieprawidłowy podpis", http.StatusUnauthorized)
+		return
+	}
+
+	issue, err := provider.parse(r, body)
+	if err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+	if issue == nil {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "ignored"})
+		return
+	}
+
+	rule := team.Inbound.match(issue)
+	if rule == nil {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "ignored"})
+		return
+	}
+
+	deliveryID := r.Header.Get(provider.deliveryHeader)
+	if deliveryID == "" {
+		sum := sha256.Sum256(body)
+		deliveryID = hex.EncodeToString(sum[:])
+	}
+	eventID := vars["provider"] + ":" + team.ID + ":" + deliveryID
+	first, err := recordInboundEvent(eventID)
+	if err != nil {
+		log.Printf("Błąd przy zapisie zdarzenia trackera: %v", err)
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+	if !first {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "duplicate"})
+		return
+	}
+
+	session, err := getSession(rule.SessionID)
+	if err != nil || session.TeamID != team.ID {
+		log.Printf("Reguła zespołu %s wskazuje sesję %s spoza zespołu", team.ID, rule.SessionID)
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "ignored"})
+		return
+	}
+	if !session.isOpen() {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "ignored"})
+		return
+	}
+
+	story := addInboundIssue(session, issue)
+	if story == nil {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "duplicate"})
+		return
+	}
+
+	// The story is appended on its own, so another delivery saved in the
+	// meantime is not overwritten.
+	added, err := appendBacklogStory(session.ID, notContainingIssue(story.ExternalKey), story, false)
+	if err != nil {
+		forgetInboundEvent(eventID)
+		http.Error(w, "Błąd przy dodawaniu user story", http.StatusInternalServerError)
+		return
+	}
+	if !added {
+		writeInboundResult(w, http.StatusOK, inboundResult{Status: "duplicate"})
+		return
+	}
+
+	notifySessionParticipants(session.ID, fmt.Sprintf("/backlog-added:%s", story.ID))
+	writeInboundResult(w, http.StatusCreated, inboundResult{Status: "added", StoryID: story.ID})
+}
+
+func updateInboundHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Rules []*InboundRule `json:"rules"`
+		// RegenerateSecret replaces the secret, e.g. after it leaked.
+		RegenerateSecret bool `json:"regenerateSecret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	team, ok := loadOwnedTeam(w, r, mux.Vars(r)["teamId"])
+	if !ok {
+		return
+	}
+
+	for _, rule := range payload.Rules {
+		if rule.Label == "" {
+			rule.Label = defaultInboundLabel
+		}
+		session, err := getSession(rule.SessionID)
+		if err != nil || session.TeamID != team.ID {
+			http.Error(w, fmt.Sprintf("Sesja %q nie należy do zespołu", rule.SessionID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	config := &InboundConfig{Rules: payload.Rules}
+	if config.Rules == nil {
+		config.Rules = []*InboundRule{}
+	}
+	if team.Inbound != nil && !payload.RegenerateSecret {
+		config.Secret = team.Inbound.Secret
+	}
+
+	newSecret := ""
+	if config.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+			return
+		}
+		config.Secret, newSecret = secret, secret
+	}
+
+	team.Inbound = config
+	if err := updateTeamInbound(team); err != nil {
+		http.Error(w, "Błąd przy zapisie reguł", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		*InboundConfig
+		// Secret is only returned when it was generated by this request.
+		Secret string `json:"secret,omitempty"`
+	}{config, newSecret})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// recordInboundEvent remembers the delivery and reports whether it is seen for
+// the first time.
+func recordInboundEvent(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := inboundEventCol.InsertOne(ctx, inboundEvent{ID: id, ReceivedAt: time.Now().UTC()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// forgetInboundEvent lets the tracker retry a delivery that failed.
+func forgetInboundEvent(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := inboundEventCol.DeleteOne(ctx, bson.M{"id": id}); err != nil {
+		log.Printf("Błąd przy usuwaniu zdarzenia trackera %s: %v", id, err)
+	}
+}
+
+type inboundEvent struct {
+	ID         string    `bson:"id"`
+	ReceivedAt time.Time `bson:"receivedat"`
+}
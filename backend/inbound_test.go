@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func inboundSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyInboundSignature(t *testing.T) {
+	body := []byte(`{"action":"labeled"}`)
+	signature := inboundSignature("sekret", string(body))
+
+	if !verifyInboundSignature("sekret", signature, body) {
+		t.Errorf("Poprawny podpis odrzucony")
+	}
+	if verifyInboundSignature("inny", signature, body) {
+		t.Errorf("Podpis z innym sekretem zaakceptowany")
+	}
+	if verifyInboundSignature("sekret", strings.TrimPrefix(signature, "sha256="), body) {
+		t.Errorf("Podpis bez prefiksu zaakceptowany")
+	}
+	if verifyInboundSignature("sekret", "", body) {
+		t.Errorf("Pusty podpis zaakceptowany")
+	}
+}
+
+func TestParseGitHubWebhook(t *testing.T) {
+	body := `{
+		"action": "labeled",
+		"issue": {"number": 12, "title": "Logowanie", "body": "Przez Google", "labels": [{"name": "bug"}, {"name": "needs-estimate"}]},
+		"repository": {"full_name": "kat/poker"}
+	}`
+	r := httptest.NewRequest("POST", "/hooks/github/team-1", strings.NewReader(body))
+	r.Header.Set("X-GitHub-Event", "issues")
+
+	issue, err := parseGitHubWebhook(r, []byte(body))
+	if err != nil || issue == nil {
+		t.Fatalf("Nie odczytano zgłoszenia: %v", err)
+	}
+	if issue.Key != "kat/poker#12" || issue.Title != "Logowanie" || issue.Project != "kat/poker" || len(issue.Labels) != 2 {
+		t.Errorf("Nieprawidłowe zgłoszenie: %+v", issue)
+	}
+
+	r.Header.Set("X-GitHub-Event", "ping")
+	if issue, _ := parseGitHubWebhook(r, []byte(body)); issue != nil {
+		t.Errorf("Zdarzenie ping nie powinno dawać zgłoszenia")
+	}
+
+	closed := strings.Replace(body, "labeled", "closed", 1)
+	r.Header.Set("X-GitHub-Event", "issues")
+	if issue, _ := parseGitHubWebhook(r, []byte(closed)); issue != nil {
+		t.Errorf("Zamknięte zgłoszenie nie powinno trafić do backlogu")
+	}
+}
+
+func TestParseJiraWebhook(t *testing.T) {
+	body := `{
+		"webhookEvent": "jira:issue_updated",
+		"issue": {"key": "KP-7", "fields": {"summary": "Eksport", "labels": ["needs-estimate"], "project": {"key": "KP"}}}
+	}`
+	r := httptest.NewRequest("POST", "/hooks/jira/team-1", strings.NewReader(body))
+
+	issue, err := parseJiraWebhook(r, []byte(body))
+	if err != nil || issue == nil {
+		t.Fatalf("Nie odczytano zgłoszenia: %v", err)
+	}
+	if issue.Key != "KP-7" || issue.Title != "Eksport" || issue.Project != "KP" {
+		t.Errorf("Nieprawidłowe zgłoszenie: %+v", issue)
+	}
+
+	deleted := strings.Replace(body, "jira:issue_updated", "jira:issue_deleted", 1)
+	if issue, _ := parseJiraWebhook(r, []byte(deleted)); issue != nil {
+		t.Errorf("Usunięte zgłoszenie nie powinno trafić do backlogu")
+	}
+}
+
+func TestInboundConfigMatch(t *testing.T) {
+	config := &InboundConfig{Rules: []*InboundRule{
+		{Label: "needs-estimate", Project: "KP", SessionID: "session-kp"},
+		{Label: "Needs-Estimate", SessionID: "session-any"},
+	}}
+
+	cases := []struct {
+		issue inboundIssue
+		want  string
+	}{
+		{inboundIssue{Project: "KP", Labels: []string{"needs-estimate"}}, "session-kp"},
+		{inboundIssue{Project: "OPS", Labels: []string{"needs-estimate"}}, "session-any"},
+		{inboundIssue{Project: "KP", Labels: []string{"bug"}}, ""},
+	}
+	for _, c := range cases {
+		rule := config.match(&c.issue)
+		got := ""
+		if rule != nil {
+			got = rule.SessionID
+		}
+		if got != c.want {
+			t.Errorf("Zgłoszenie %+v: oczekiwano sesji %q, otrzymano %q", c.issue, c.want, got)
+		}
+	}
+}
+
+func TestAddInboundIssueSkipsDuplicates(t *testing.T) {
+	session := &Session{ID: "session-1", Backlog: []*Story{}}
+	issue := &inboundIssue{Key: "KP-7", Title: "Eksport"}
+
+	story := addInboundIssue(session, issue)
+	if story == nil || story.ExternalKey != "KP-7" || len(session.Backlog) != 1 {
+		t.Fatalf("Zgłoszenie nie trafiło do backlogu: %+v", session.Backlog)
+	}
+
+	if again := addInboundIssue(session, issue); again != nil || len(session.Backlog) != 1 {
+		t.Errorf("Zgłoszenie zostało dodane ponownie")
+	}
+}
+
+func TestAppendInboundIssuesConcurrently(t *testing.T) {
+	requireMongo(t)
+
+	session := &Session{Name: "Tracker"}
+	prepareSession(session, "")
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"KP-1", "KP-2", "KP-3", "KP-1"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			story := newStory("Zgłoszenie "+key, "", "")
+			story.ExternalKey = key
+			if _, err := appendBacklogStory(session.ID, notContainingIssue(key), story, false); err != nil {
+				t.Errorf("Błąd przy dodawaniu %s: %v", key, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	saved, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]int{}
+	for _, story := range saved.Backlog {
+		keys[story.ExternalKey]++
+	}
+	if len(saved.Backlog) != 3 || keys["KP-1"] != 1 || keys["KP-2"] != 1 || keys["KP-3"] != 1 {
+		t.Errorf("Oczekiwano każdego zgłoszenia raz, otrzymano %v", keys)
+	}
+	if saved.BacklogVersion != 3 {
+		t.Errorf("Oczekiwano wersji backlogu 3, otrzymano %d", saved.BacklogVersion)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// jiraTracker talks to the Jira REST API v2 with an account e-mail and an
+// API token.
+type jiraTracker struct {
+	config TrackerConfig
+	client *http.Client
+}
+
+var jiraKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]+-\d+$`)
+
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary     string `json:"summary"`
+		Description string `json:"description"`
+	} `json:"fields"`
+}
+
+func (t *jiraTracker) authorize(req *http.Request) {
+	req.SetBasicAuth(t.config.Username, t.config.Token)
+}
+
+func (t *jiraTracker) url(path string) string {
+	return strings.TrimRight(t.config.BaseURL, "/") + path
+}
+
+func (t *jiraTracker) issue(raw jiraIssue) Issue {
+	return Issue{
+		Key:         raw.Key,
+		Title:       raw.Fields.Summary,
+		Description: raw.Fields.Description,
+		URL:         t.url("/browse/" + raw.Key),
+	}
+}
+
+// jql builds the search for the query: an exact issue key, or a text search
+// within the configured project.
+func (t *jiraTracker) jql(query string) string {
+	query = strings.TrimSpace(query)
+	if jiraKeyPattern.MatchString(query) {
+		return "key = " + query
+	}
+
+	var clauses []string
+	if t.config.Project != "" {
+		clauses = append(clauses, fmt.Sprintf("project = %q", t.config.Project))
+	}
+	if query != "" {
+		clauses = append(clauses, fmt.Sprintf("text ~ %q", query))
+	}
+	return strings.TrimSpace(strings.Join(clauses, " AND ") + " ORDER BY created DESC")
+}
+
+func (t *jiraTracker) SearchIssues(ctx context.Context, query string) ([]Issue, error) {
+	params := url.Values{}
+	params.Set("jql", t.jql(query))
+	params.Set("fields", "summary,description")
+	params.Set("maxResults", "50")
+
+	var result struct {
+		Issues []jiraIssue `json:"issues"`
+	}
+	if err := doTrackerRequest(ctx, t.client, http.MethodGet, t.url("/rest/api/2/search?"+params.Encode()), t.authorize, nil, &result); err != nil {
+		return nil, err
+	}
+
+	issues := make([]Issue, 0, len(result.Issues))
+	for _, raw := range result.Issues {
+		issues = append(issues, t.issue(raw))
+	}
+	return issues, nil
+}
+
+func (t *jiraTracker) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	var raw jiraIssue
+	path := "/rest/api/2/issue/" + url.PathEscape(key) + "?fields=summary,description"
+	if err := doTrackerRequest(ctx, t.client, http.MethodGet, t.url(path), t.authorize, nil, &raw); err != nil {
+		return nil, err
+	}
+	issue := t.issue(raw)
+	return &issue, nil
+}
+
+func (t *jiraTracker) SetStoryPoints(ctx context.Context, key string, points int) error {
+	body := map[string]any{
+		"fields": map[string]any{t.config.StoryPointsField: points},
+	}
+	return doTrackerRequest(ctx, t.client, http.MethodPut, t.url("/rest/api/2/issue/"+url.PathEscape(key)), t.authorize, body, nil)
+}
+
+func (t *jiraTracker) AddComment(ctx context.Context, key, comment string) error {
+	body := map[string]string{"body": comment}
+	return doTrackerRequest(ctx, t.client, http.MethodPost, t.url("/rest/api/2/issue/"+url.PathEscape(key)+"/comment"), t.authorize, body, nil)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	sessionOpen     = "open"
+	sessionClosed   = "closed"
+	sessionArchived = "archived"
+)
+
+// isOpen reports whether the session accepts changes. Sessions saved before
+// the status was introduced have none and are open.
+func (s *Session) isOpen() bool {
+	return s.Status == "" || s.Status == sessionOpen
+}
+
+// openStatusFilter matches the status of open sessions.
+func openStatusFilter() bson.M {
+	return bson.M{"$in": bson.A{sessionOpen, "", nil}}
+}
+
+// close freezes the session. It reports false when it was not open.
+func (s *Session) close(now time.Time) bool {
+	if !s.isOpen() {
+		return false
+	}
+	s.Status = sessionClosed
+	s.ClosedAt = &now
+	return true
+}
+
+// archive makes the session read-only for good, closing it first when needed.
+// It reports whether the session was closed by the call.
+func (s *Session) archive(now time.Time) bool {
+	closed := s.close(now)
+	s.Status = sessionArchived
+	s.ArchivedAt = &now
+	return closed
+}
+
+// sessionEndedData is sent with session.ended.
+type sessionEndedData struct {
+	// Reason is "closed", "archived" or "expired".
+	Reason    string `json:"reason"`
+	Rounds    int    `json:"rounds"`
+	Estimated int    `json:"estimated"`
+	Points    int    `json:"points"`
+}
+
+func newSessionEndedData(session *Session, reason string) sessionEndedData {
+	summary := newChatSessionSummary(session)
+	rounds := len(session.RoundHistory)
+	if session.CurrentRound != nil {
+		rounds++
+	}
+	return sessionEndedData{
+		Reason:    reason,
+		Rounds:    rounds,
+		Estimated: summary.Estimated,
+		Points:    summary.Points,
+	}
+}
+
+// disconnectSession sends the final message to every client of the session,
+// after which their writers close the connections. The readers started in
+// sessionWebSocket then remove them.
+func disconnectSession(sessionID, message string) {
+	sessionHub.post(sessionID, wsMessage{text: message, closing: true}, nil)
+}
+
+// endSession notifies everyone that the session is over. It is called after
+// the session was saved as closed.
+func endSession(session *Session, reason string) {
+	disconnectSession(session.ID, "/session-closed")
+	publishEvent(session, eventSessionEnded, newSessionEndedData(session, reason))
+}
+
+// sessionStatusMiddleware keeps closed and archived sessions read-only: only
+// reads, and for closed sessions the lifecycle endpoints, are let through.
+func sessionStatusMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, ok := mux.Vars(r)["id"]
+		if !ok || !sessionRouteWrites(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := getSession(sessionID)
+		if err != nil || session.isOpen() {
+			// Missing sessions are reported by the handler.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if session.Status == sessionClosed && isLifecycleRoute(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if session.Status == sessionArchived {
+			http.Error(w, "Sesja jest zarchiwizowana i tylko do odczytu", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Sesja jest zamknięta", http.StatusConflict)
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, _ := route.GetPathTemplate()
+	return template
+}
+
+// sessionRouteWrites reports whether the request changes the session. New
+// WebSocket connections count too, since they join the session.
+func sessionRouteWrites(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		return true
+	}
+	return routeTemplate(r) == "/sessions/{id}/ws"
+}
+
+func isLifecycleRoute(r *http.Request) bool {
+	switch routeTemplate(r) {
+	case "/sessions/{id}/close", "/sessions/{id}/reopen", "/sessions/{id}/archive":
+		return true
+	}
+	return false
+}
+
+func writeSessionStatus(w http.ResponseWriter, session *Session) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		ID         string     `json:"id"`
+		Status     string     `json:"status"`
+		ClosedAt   *time.Time `json:"closedAt,omitempty"`
+		ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	}{session.ID, session.Status, session.ClosedAt, session.ArchivedAt})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// loadSessionForLifecycle loads the session and checks that the request comes
+// from its facilitator.
+func loadSessionForLifecycle(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	session, err := getSession(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return nil, false
+	}
+	if !requireFacilitator(w, r, session) {
+		return nil, false
+	}
+	return session, true
+}
+
+func closeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadSessionForLifecycle(w, r)
+	if !ok {
+		return
+	}
+
+	if !session.close(time.Now().UTC()) {
+		http.Error(w, "Sesja jest już zamknięta", http.StatusConflict)
+		return
+	}
+
+	saved, err := saveSessionStatus(session, bson.M{"status": openStatusFilter()})
+	if err != nil {
+		http.Error(w, "Błąd przy zamykaniu sesji", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Sesja jest już zamknięta", http.StatusConflict)
+		return
+	}
+
+	endSession(session, sessionClosed)
+	writeSessionStatus(w, session)
+}
+
+func reopenSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadSessionForLifecycle(w, r)
+	if !ok {
+		return
+	}
+
+	if session.Status != sessionClosed {
+		http.Error(w, "Można otworzyć tylko zamkniętą sesję", http.StatusConflict)
+		return
+	}
+
+	session.Status = sessionOpen
+	session.ClosedAt = nil
+	session.LastActivityAt = time.Now().UTC()
+	saved, err := saveSessionStatus(session, bson.M{"status": sessionClosed})
+	if err != nil {
+		http.Error(w, "Błąd przy otwieraniu sesji", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Można otworzyć tylko zamkniętą sesję", http.StatusConflict)
+		return
+	}
+
+	writeSessionStatus(w, session)
+}
+
+func archiveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadSessionForLifecycle(w, r)
+	if !ok {
+		return
+	}
+
+	if session.Status == sessionArchived {
+		http.Error(w, "Sesja jest już zarchiwizowana", http.StatusConflict)
+		return
+	}
+
+	closed := session.archive(time.Now().UTC())
+	saved, err := saveSessionStatus(session, bson.M{"status": bson.M{"$ne": sessionArchived}})
+	if err != nil {
+		http.Error(w, "Błąd przy archiwizacji sesji", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Sesja jest już zarchiwizowana", http.StatusConflict)
+		return
+	}
+
+	if closed {
+		endSession(session, sessionArchived)
+	}
+	writeSessionStatus(w, session)
+}
+
+// sessionExpiry configures the sweeper. A zero duration turns the step off.
+type sessionExpiry struct {
+	// CloseAfter closes open sessions without activity for this long.
+	CloseAfter time.Duration
+	// ArchiveAfter archives sessions closed for this long.
+	ArchiveAfter time.Duration
+	// DeleteAfter deletes sessions archived for this long.
+	DeleteAfter time.Duration
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	if value == "0" || value == "off" {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Nieprawidłowa wartość %s=%q, używam %v", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// sessionExpiryFromEnv reads SESSION_CLOSE_AFTER, SESSION_ARCHIVE_AFTER and
+// SESSION_DELETE_AFTER. Archived sessions are kept unless the last one is set.
+func sessionExpiryFromEnv() sessionExpiry {
+	return sessionExpiry{
+		CloseAfter:   durationFromEnv("SESSION_CLOSE_AFTER", 14*24*time.Hour),
+		ArchiveAfter: durationFromEnv("SESSION_ARCHIVE_AFTER", 30*24*time.Hour),
+		DeleteAfter:  durationFromEnv("SESSION_DELETE_AFTER", 0),
+	}
+}
+
+// filters returns the queries for the sessions each step of the
+// sweeper applies to.
+func (e sessionExpiry) filters(now time.Time) (closeFilter, archiveFilter, deleteFilter bson.M) {
+	if e.CloseAfter > 0 {
+		closeFilter = bson.M{
+			"status":         openStatusFilter(),
+			"lastactivityat": bson.M{"$lt": now.Add(-e.CloseAfter)},
+		}
+	}
+	if e.ArchiveAfter > 0 {
+		archiveFilter = bson.M{
+			"status":   sessionClosed,
+			"closedat": bson.M{"$lt": now.Add(-e.ArchiveAfter)},
+		}
+	}
+	if e.DeleteAfter > 0 {
+		deleteFilter = bson.M{
+			"status":     sessionArchived,
+			"archivedat": bson.M{"$lt": now.Add(-e.DeleteAfter)},
+		}
+	}
+	return closeFilter, archiveFilter, deleteFilter
+}
+
+// sweepSessions closes, archives and deletes the sessions past their expiry.
+func sweepSessions(expiry sessionExpiry, now time.Time) {
+	closeFilter, archiveFilter, deleteFilter := expiry.filters(now)
+
+	if closeFilter != nil {
+		sessions, err := findSessions(closeFilter)
+		if err != nil {
+			log.Printf("Błąd przy wyszukiwaniu nieaktywnych sesji: %v", err)
+		}
+		for _, session := range sessions {
+			session.close(now)
+			// The condition skips sessions used since they were read.
+			saved, err := saveSessionStatus(session, bson.M{"lastactivityat": session.LastActivityAt, "status": openStatusFilter()})
+			if err != nil {
+				log.Printf("Błąd przy zamykaniu sesji %s: %v", session.ID, err)
+				continue
+			}
+			if saved {
+				endSession(session, "expired")
+			}
+		}
+	}
+
+	if archiveFilter != nil {
+		if count, err := updateSessions(archiveFilter, bson.M{"status": sessionArchived, "archivedat": now}); err != nil {
+			log.Printf("Błąd przy archiwizacji sesji: %v", err)
+		} else if count > 0 {
+			log.Printf("Zarchiwizowano %d zamkniętych sesji", count)
+		}
+	}
+
+	if deleteFilter != nil {
+		if count, err := deleteSessions(deleteFilter); err != nil {
+			log.Printf("Błąd przy usuwaniu zarchiwizowanych sesji: %v", err)
+		} else if count > 0 {
+			log.Printf("Usunięto %d zarchiwizowanych sesji", count)
+		}
+	}
+}
+
+// startSessionSweeper runs sweepSessions every hour.
+func startSessionSweeper(expiry sessionExpiry) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			sweepSessions(expiry, time.Now().UTC())
+			<-ticker.C
+		}
+	}()
+}
+
+func findSessions(filter bson.M) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := sessionCol.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func updateSessions(filter, set bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := sessionCol.UpdateMany(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func deleteSessions(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := sessionCol.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSessionCloseAndArchive(t *testing.T) {
+	now := time.Now().UTC()
+
+	legacy := &Session{}
+	if !legacy.isOpen() {
+		t.Errorf("Sesja bez statusu powinna być otwarta")
+	}
+
+	session := &Session{Status: sessionOpen}
+	if !session.close(now) || session.Status != sessionClosed || session.ClosedAt == nil {
+		t.Fatalf("Sesja nie została zamknięta: %+v", session)
+	}
+	if session.close(now) {
+		t.Errorf("Zamknięta sesja nie powinna zamknąć się ponownie")
+	}
+	if session.archive(now) || session.Status != sessionArchived || session.ArchivedAt == nil {
+		t.Errorf("Archiwizacja zamkniętej sesji nie powinna jej ponownie zamykać: %+v", session)
+	}
+
+	open := &Session{Status: sessionOpen}
+	if !open.archive(now) || open.ClosedAt == nil || open.Status != sessionArchived {
+		t.Errorf("Archiwizacja otwartej sesji powinna ją zamknąć: %+v", open)
+	}
+}
+
+func TestSessionRouteWrites(t *testing.T) {
+	var writes, lifecycle bool
+	r := mux.NewRouter()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		writes, lifecycle = sessionRouteWrites(r), isLifecycleRoute(r)
+	}
+	r.HandleFunc("/sessions/{id}", handler).Methods("GET")
+	r.HandleFunc("/sessions/{id}/ws", handler).Methods("GET")
+	r.HandleFunc("/sessions/{id}/vote", handler).Methods("POST")
+	r.HandleFunc("/sessions/{id}/reopen", handler).Methods("POST")
+
+	cases := []struct {
+		method, path      string
+		writes, lifecycle bool
+	}{
+		{"GET", "/sessions/s1", false, false},
+		{"GET", "/sessions/s1/ws", true, false},
+		{"POST", "/sessions/s1/vote", true, false},
+		{"POST", "/sessions/s1/reopen", true, true},
+	}
+	for _, c := range cases {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, c.path, nil))
+		if writes != c.writes || lifecycle != c.lifecycle {
+			t.Errorf("%s %s: oczekiwano zapisu %v i cyklu życia %v, otrzymano %v i %v",
+				c.method, c.path, c.writes, c.lifecycle, writes, lifecycle)
+		}
+	}
+}
+
+func TestSessionExpiryFilters(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expiry := sessionExpiry{CloseAfter: 24 * time.Hour, ArchiveAfter: 48 * time.Hour}
+
+	closeFilter, archiveFilter, deleteFilter := expiry.filters(now)
+	if closeFilter["lastactivityat"].(bson.M)["$lt"] != now.Add(-24*time.Hour) {
+		t.Errorf("Nieprawidłowy filtr zamykania: %v", closeFilter)
+	}
+	if archiveFilter["status"] != sessionClosed || archiveFilter["closedat"].(bson.M)["$lt"] != now.Add(-48*time.Hour) {
+		t.Errorf("Nieprawidłowy filtr archiwizacji: %v", archiveFilter)
+	}
+	if deleteFilter != nil {
+		t.Errorf("Usuwanie powinno być domyślnie wyłączone: %v", deleteFilter)
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+	t.Setenv("SESSION_CLOSE_AFTER", "72h")
+	t.Setenv("SESSION_ARCHIVE_AFTER", "off")
+	t.Setenv("SESSION_DELETE_AFTER", "wkrótce")
+
+	expiry := sessionExpiryFromEnv()
+	if expiry.CloseAfter != 72*time.Hour || expiry.ArchiveAfter != 0 || expiry.DeleteAfter != 0 {
+		t.Errorf("Nieprawidłowa konfiguracja: %+v", expiry)
+	}
+}
+
+func TestMigrateSessionLifecycle(t *testing.T) {
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	started := created.Add(48 * time.Hour)
+	doc := bson.M{
+		"id":           "session-" + strconv.FormatInt(created.UnixNano(), 10),
+		"currentround": bson.M{"id": "round-2", "startedat": primitive.NewDateTimeFromTime(started)},
+		"roundhistory": bson.A{bson.M{"id": "round-1", "startedat": primitive.NewDateTimeFromTime(created.Add(time.Hour))}},
+	}
+
+	changed, err := migrateSessionLifecycle(doc)
+	if err != nil || !changed {
+		t.Fatalf("Dokument powinien się zmienić: %v", err)
+	}
+	if doc["status"] != sessionOpen || !doc["createdat"].(time.Time).Equal(created) {
+		t.Errorf("Nieprawidłowy status lub data utworzenia: %v", doc)
+	}
+	if !doc["lastactivityat"].(time.Time).Equal(started) {
+		t.Errorf("Oczekiwano ostatniej aktywności %v, otrzymano %v", started, doc["lastactivityat"])
+	}
+
+	if changed, _ := migrateSessionLifecycle(doc); changed {
+		t.Errorf("Zmigrowany dokument nie powinien się zmienić")
+	}
+}
+
+func TestSessionUpdateLeavesStatusAlone(t *testing.T) {
+	now := time.Now().UTC()
+	session := &Session{ID: "session-1", Name: "Sprint 42", Status: sessionOpen, ClosedAt: &now}
+
+	update, err := sessionUpdate(session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, onInsert := update["$set"].(bson.M), update["$setOnInsert"].(bson.M)
+	for _, field := range sessionStatusFields {
+		if _, ok := set[field]; ok {
+			t.Errorf("Zapis sesji nie powinien zmieniać pola %s", field)
+		}
+		if _, ok := onInsert[field]; !ok {
+			t.Errorf("Nowa sesja powinna dostać pole %s", field)
+		}
+	}
+	if set["name"] != "Sprint 42" || onInsert["status"] != sessionOpen {
+		t.Errorf("Nieoczekiwana aktualizacja: %v", update)
+	}
+	if _, ok := set["lastactivityat"]; ok {
+		t.Errorf("Zapis bez działań uczestników nie powinien zmieniać ostatniej aktywności")
+	}
+
+	update, err = sessionUpdate(session, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := update["$set"].(bson.M)["lastactivityat"]; !ok || time.Since(session.LastActivityAt) > time.Minute {
+		t.Errorf("Działanie uczestników powinno zmienić ostatnią aktywność: %v", update)
+	}
+}
+
+func TestStaleSaveDoesNotReopenSession(t *testing.T) {
+	requireMongo(t)
+
+	session := &Session{Name: "Sprint 42"}
+	prepareSession(session, "")
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// A vote loaded the session before it was closed and saves it afterwards.
+	stale, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.close(time.Now().UTC())
+	if saved, err := saveSessionStatus(session, bson.M{"status": openStatusFilter()}); err != nil || !saved {
+		t.Fatalf("Nie zamknięto sesji: %v, %v", saved, err)
+	}
+	if saved, err := saveSessionStatus(session, bson.M{"status": openStatusFilter()}); err != nil || saved {
+		t.Errorf("Ponowne zamknięcie powinno się nie udać: %v, %v", saved, err)
+	}
+
+	stale.Players = append(stale.Players, "Ala")
+	if err := saveSession(stale); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != sessionClosed || saved.ClosedAt == nil {
+		t.Errorf("Zapis sprzed zamknięcia otworzył sesję: %s, %v", saved.Status, saved.ClosedAt)
+	}
+}
+
+func TestOnlyParticipantActionsBumpLastActivity(t *testing.T) {
+	requireMongo(t)
+
+	session := &Session{Name: "Sprint 42"}
+	prepareSession(session, "")
+	session.LastActivityAt = time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Millisecond)
+	session.CreatedAt = session.LastActivityAt
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	lastActivity := session.LastActivityAt
+
+	session.Settings.OutlierRule = outlierRuleNone
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.LastActivityAt.Equal(lastActivity) {
+		t.Errorf("Zmiana ustawień zmieniła ostatnią aktywność: %v, oczekiwano %v", saved.LastActivityAt, lastActivity)
+	}
+
+	saved.Players = append(saved.Players, "Ala")
+	if err := saveSessionActivity(saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = getSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.LastActivityAt.After(lastActivity) {
+		t.Errorf("Dołączenie gracza nie zmieniło ostatniej aktywności: %v", saved.LastActivityAt)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text e-mails to users.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+var mailer Mailer = newMailerFromEnv()
+
+// newMailerFromEnv returns an SMTP mailer when SMTP_HOST is set. Otherwise
+// messages are written to MAIL_DIR (or only logged when it is empty), which is
+// enough for local development.
+func newMailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &fileMailer{dir: os.Getenv("MAIL_DIR")}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@kat-poker.app"
+	}
+
+	return &smtpMailer{
+		host:     host,
+		port:     port,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     from,
+	}
+}
+
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := m.host + ":" + m.port
+	if err := smtp.SendMail(addr, auth, m.from, []string{to}, buildMessage(m.from, to, subject, body)); err != nil {
+		return fmt.Errorf("błąd przy wysyłaniu wiadomości: %w", err)
+	}
+	return nil
+}
+
+// fileMailer stores every message as a separate .eml file instead of sending it.
+type fileMailer struct {
+	dir string
+}
+
+func (m *fileMailer) Send(to, subject, body string) error {
+	if m.dir == "" {
+		log.Printf("E-mail do %s: %s\n%s", to, subject, body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("błąd przy tworzeniu katalogu poczty: %w", err)
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	message := buildMessage("no-reply@localhost", to, subject, body)
+	if err := os.WriteFile(filepath.Join(m.dir, name), message, 0o644); err != nil {
+		return fmt.Errorf("błąd przy zapisie wiadomości: %w", err)
+	}
+
+	log.Printf("E-mail do %s zapisany w %s", to, name)
+	return nil
+}
+
+func buildMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -11,6 +12,18 @@ import (
 
 func main() {
 	initMongoDB()
+
+	store := &mongoMigrationStore{db: client.Database("planning")}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(store, os.Args[2:])
+		return
+	}
+	migrateOnStartup(store)
+
+	startWebhooks(&mongoWebhookStore{subscriptions: webhookCol, deliveries: deliveryCol})
+	startChatNotifier()
+	startSessionSweeper(sessionExpiryFromEnv())
+
 	corsOptions := cors.New(cors.Options{
 		AllowedOrigins: []string{
 			"http://localhost:3000",
@@ -22,7 +35,7 @@ func main() {
 			"kat-poker.vercel.app/*",
 			"*",
 		},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "WEBSOCKET"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "WEBSOCKET"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization", "Application", "application/json"},
 		AllowCredentials: true,
 	})
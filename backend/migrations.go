@@ -0,0 +1,767 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migration upgrades the raw documents of one collection. Every document keeps
+// the version of the last migration applied to it in its schemaversion field,
+// so an interrupted run simply continues where it stopped.
+type migration struct {
+	Version     int
+	Collection  string
+	Description string
+	// Up changes the document in place and reports whether it changed.
+	Up func(doc bson.M) (bool, error)
+}
+
+// migrations must stay ordered by Version. Never change a migration that has
+// already been released; add a new one instead.
+var migrations = []migration{
+	{
+		Version:     1,
+		Collection:  "sessions",
+		Description: "round votes keyed by story index",
+		Up:          migrateVotesPerStory,
+	},
+	{
+		Version:     2,
+		Collection:  "users",
+		Description: "normalized username keys",
+		Up:          migrateUsernameKey,
+	},
+	{
+		Version:     3,
+		Collection:  "sessions",
+		Description: "stories with stable IDs",
+		Up:          migrateStoryIDs,
+	},
+	{
+		Version:     4,
+		Collection:  "sessions",
+		Description: "story tasks as subtasks",
+		Up:          migrateTasksToSubtasks,
+	},
+	{
+		Version:     5,
+		Collection:  "sessions",
+		Description: "session status and activity",
+		Up:          migrateSessionLifecycle,
+	},
+	{
+		Version:     6,
+		Collection:  "sessions",
+		Description: "session members from facilitators and story authors",
+		Up:          migrateSessionMembers,
+	},
+}
+
+// errMigrationConflict is returned by a store when an upgraded document would
+// break a unique index. The document is left as it was and reported.
+var errMigrationConflict = errors.New("konflikt unikalności")
+
+// migrationRecord is stored in the migrations collection for every migration
+// that was applied to all documents.
+type migrationRecord struct {
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	Documents   int       `bson:"documents"`
+	AppliedAt   time.Time `bson:"appliedat"`
+}
+
+type migrationStore interface {
+	// Documents returns the documents of the collection whose schemaversion is
+	// lower than version or missing.
+	Documents(collection string, version int) ([]bson.M, error)
+	// ReplaceDocument overwrites the document with the same _id.
+	ReplaceDocument(collection string, doc bson.M) error
+	AppliedMigrations() (map[int]bool, error)
+	RecordMigration(record migrationRecord) error
+}
+
+type migrationReport struct {
+	Version     int
+	Collection  string
+	Description string
+	Applied     bool
+	Checked     int
+	Changed     int
+	Conflicts   int
+}
+
+func (r migrationReport) String() string {
+	if r.Applied {
+		return fmt.Sprintf("%d %s (%s): już zastosowana", r.Version, r.Description, r.Collection)
+	}
+	return fmt.Sprintf("%d %s (%s): sprawdzono %d, zmieniono %d, konflikty %d",
+		r.Version, r.Description, r.Collection, r.Checked, r.Changed, r.Conflicts)
+}
+
+// latestSchemaVersion is the version written to new documents of the
+// collection, so that migrations never touch them.
+func latestSchemaVersion(collection string) int {
+	version := 0
+	for _, m := range migrations {
+		if m.Collection == collection && m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+// runMigrations applies the pending migrations in order. With dryRun set the
+// documents are upgraded only in memory and the report shows what would change.
+func runMigrations(store migrationStore, list []migration, dryRun bool) ([]migrationReport, error) {
+	applied, err := store.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		store = newDryRunMigrationStore(store)
+	}
+
+	var reports []migrationReport
+	for _, m := range list {
+		report := migrationReport{Version: m.Version, Collection: m.Collection, Description: m.Description}
+		if applied[m.Version] {
+			report.Applied = true
+			reports = append(reports, report)
+			continue
+		}
+
+		docs, err := store.Documents(m.Collection, m.Version)
+		if err != nil {
+			return reports, fmt.Errorf("migracja %d: %w", m.Version, err)
+		}
+
+		for _, doc := range docs {
+			report.Checked++
+			changed, err := m.Up(doc)
+			if err != nil {
+				return reports, fmt.Errorf("migracja %d, dokument %v: %w", m.Version, doc["_id"], err)
+			}
+			if changed {
+				report.Changed++
+			}
+
+			doc["schemaversion"] = m.Version
+			if err := store.ReplaceDocument(m.Collection, doc); err != nil {
+				if errors.Is(err, errMigrationConflict) {
+					log.Printf("Migracja %d: pominięto dokument %v: %v", m.Version, doc["_id"], err)
+					report.Conflicts++
+					continue
+				}
+				return reports, fmt.Errorf("migracja %d, dokument %v: %w", m.Version, doc["_id"], err)
+			}
+		}
+
+		// A migration with conflicts is retried on the next run, after the
+		// conflicting documents have been fixed by hand.
+		if !dryRun && report.Conflicts == 0 {
+			err := store.RecordMigration(migrationRecord{
+				Version:     m.Version,
+				Description: m.Description,
+				Documents:   report.Changed,
+				AppliedAt:   time.Now().UTC(),
+			})
+			if err != nil {
+				return reports, fmt.Errorf("migracja %d: %w", m.Version, err)
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// runMigrateCommand implements "go run . migrate [-dry-run]".
+func runMigrateCommand(store migrationStore, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	_ = flags.Parse(args)
+
+	reports, err := runMigrations(store, migrations, *dryRun)
+	for _, report := range reports {
+		log.Println(report)
+	}
+	if err != nil {
+		log.Fatalf("Migration error: %v", err)
+	}
+	if *dryRun {
+		log.Println("Dry run: no documents were changed")
+	}
+}
+
+func migrateOnStartup(store migrationStore) {
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		return
+	}
+
+	reports, err := runMigrations(store, migrations, false)
+	if err != nil {
+		log.Fatalf("Migration error: %v", err)
+	}
+	for _, report := range reports {
+		if !report.Applied {
+			log.Println("Migration", report)
+		}
+	}
+}
+
+// migrateVotesPerStory wraps the old flat round votes ({"player": 5}) in a map
+// keyed by the story that was active ({"0": {"player": 5}}).
+func migrateVotesPerStory(doc bson.M) (bool, error) {
+	changed := false
+
+	if round, ok := doc["currentround"].(bson.M); ok {
+		changed = migrateRoundVotes(round) || changed
+	}
+
+	if history, ok := doc["roundhistory"].(bson.A); ok {
+		for _, item := range history {
+			if round, ok := item.(bson.M); ok {
+				changed = migrateRoundVotes(round) || changed
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+func migrateRoundVotes(round bson.M) bool {
+	votes, ok := round["votes"].(bson.M)
+	if !ok {
+		return false
+	}
+
+	flat := bson.M{}
+	for key, value := range votes {
+		if _, nested := value.(bson.M); !nested {
+			flat[key] = value
+			delete(votes, key)
+		}
+	}
+	if len(flat) == 0 {
+		return false
+	}
+
+	story := "0"
+	switch active := round["activestory"].(type) {
+	case int:
+		story = strconv.Itoa(active)
+	case int32:
+		story = strconv.Itoa(int(active))
+	case int64:
+		story = strconv.FormatInt(active, 10)
+	}
+
+	if existing, ok := votes[story].(bson.M); ok {
+		for player, value := range flat {
+			if _, voted := existing[player]; !voted {
+				existing[player] = value
+			}
+		}
+	} else {
+		votes[story] = flat
+	}
+	return true
+}
+
+func migrateUsernameKey(doc bson.M) (bool, error) {
+	if key, _ := doc["usernamekey"].(string); key != "" {
+		return false, nil
+	}
+
+	username, _ := doc["username"].(string)
+	if username == "" {
+		return false, nil
+	}
+
+	doc["usernamekey"] = usernameKey(username)
+	return true, nil
+}
+
+// migrateStoryIDs turns the user_stories list of titles into story objects
+// with generated IDs and re-keys votes, tasks and the active story from slice
+// indexes to those IDs.
+func migrateStoryIDs(doc bson.M) (bool, error) {
+	changed := false
+
+	if round, ok := doc["currentround"].(bson.M); ok {
+		changed = migrateRoundStories(round) || changed
+	}
+
+	if history, ok := doc["roundhistory"].(bson.A); ok {
+		for _, item := range history {
+			if round, ok := item.(bson.M); ok {
+				changed = migrateRoundStories(round) || changed
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+func migrateRoundStories(round bson.M) bool {
+	if _, done := round["stories"]; done {
+		return false
+	}
+
+	ids := make(map[string]string)
+	stories := bson.A{}
+	titles, _ := round["user_stories"].(bson.A)
+	for i, item := range titles {
+		title, _ := item.(string)
+		id := uuid.New().String()
+		ids[strconv.Itoa(i)] = id
+		stories = append(stories, bson.M{
+			"id":        id,
+			"title":     title,
+			"createdat": time.Now().UTC(),
+		})
+	}
+	round["stories"] = stories
+	delete(round, "user_stories")
+
+	if votes, ok := round["votes"].(bson.M); ok {
+		rekeyed := bson.M{}
+		for index, value := range votes {
+			key, ok := ids[index]
+			if !ok {
+				// Votes cast while the round had no stories.
+				key = noStoryVotes
+			}
+			if _, exists := rekeyed[key]; !exists {
+				rekeyed[key] = value
+			}
+		}
+		round["votes"] = rekeyed
+	}
+
+	if tasks, ok := round["tasks"].(bson.M); ok {
+		rekeyed := bson.M{}
+		for index, value := range tasks {
+			// Tasks of deleted stories were never cleaned up; they are dropped.
+			if id, ok := ids[index]; ok {
+				rekeyed[id] = value
+			}
+		}
+		round["tasks"] = rekeyed
+	}
+
+	active := ""
+	switch index := round["activestory"].(type) {
+	case int:
+		active = ids[strconv.Itoa(index)]
+	case int32:
+		active = ids[strconv.Itoa(int(index))]
+	case int64:
+		active = ids[strconv.FormatInt(index, 10)]
+	}
+	round["activestory"] = active
+
+	return true
+}
+
+// migrateTasksToSubtasks moves the single task kept per story in the round's
+// tasks map into the subtasks list of that story.
+func migrateTasksToSubtasks(doc bson.M) (bool, error) {
+	changed := false
+
+	if round, ok := doc["currentround"].(bson.M); ok {
+		changed = migrateRoundTasks(round) || changed
+	}
+
+	if history, ok := doc["roundhistory"].(bson.A); ok {
+		for _, item := range history {
+			if round, ok := item.(bson.M); ok {
+				changed = migrateRoundTasks(round) || changed
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+func migrateRoundTasks(round bson.M) bool {
+	tasks, ok := round["tasks"]
+	if !ok {
+		return false
+	}
+	delete(round, "tasks")
+
+	byStory, _ := tasks.(bson.M)
+	stories, _ := round["stories"].(bson.A)
+	for _, item := range stories {
+		story, ok := item.(bson.M)
+		if !ok {
+			continue
+		}
+		id, _ := story["id"].(string)
+		text, _ := byStory[id].(string)
+		if text == "" {
+			continue
+		}
+		subtasks, _ := story["subtasks"].(bson.A)
+		story["subtasks"] = append(subtasks, bson.M{
+			"id":   uuid.New().String(),
+			"text": text,
+			"done": false,
+		})
+	}
+
+	return true
+}
+
+// migrateSessionLifecycle marks existing sessions as open. The creation time
+// comes from the nanoseconds in the session ID and the last activity from the
+// latest round, so that old sessions expire instead of all living another
+// full period.
+func migrateSessionLifecycle(doc bson.M) (bool, error) {
+	if _, ok := doc["status"]; ok {
+		return false, nil
+	}
+	doc["status"] = sessionOpen
+
+	id, _ := doc["id"].(string)
+	created := time.Now().UTC()
+	if nanos, err := strconv.ParseInt(strings.TrimPrefix(id, "session-"), 10, 64); err == nil {
+		created = time.Unix(0, nanos).UTC()
+	}
+	if _, ok := doc["createdat"]; !ok {
+		doc["createdat"] = created
+	}
+
+	lastActivity := created
+	rounds, _ := doc["roundhistory"].(bson.A)
+	if round, ok := doc["currentround"].(bson.M); ok {
+		rounds = append(rounds, round)
+	}
+	for _, item := range rounds {
+		round, _ := item.(bson.M)
+		if started, ok := bsonTime(round["startedat"]); ok && started.After(lastActivity) {
+			lastActivity = started
+		}
+	}
+	doc["lastactivityat"] = lastActivity
+
+	return true, nil
+}
+
+// migrateSessionMembers records the users known to have taken part in
+// sessions from before members were recorded: the facilitator and the authors
+// of stories. Player names are not used, since guests can type any name.
+func migrateSessionMembers(doc bson.M) (bool, error) {
+	members, _ := doc["members"].(bson.A)
+	known := make(map[string]bool, len(members))
+	for _, member := range members {
+		if id, ok := member.(string); ok {
+			known[id] = true
+		}
+	}
+
+	changed := false
+	add := func(id any) {
+		if id, ok := id.(string); ok && id != "" && !known[id] {
+			known[id] = true
+			members = append(members, id)
+			changed = true
+		}
+	}
+
+	add(doc["facilitatorid"])
+	stories, _ := doc["backlog"].(bson.A)
+	rounds, _ := doc["roundhistory"].(bson.A)
+	if round, ok := doc["currentround"].(bson.M); ok {
+		rounds = append(rounds, round)
+	}
+	for _, item := range rounds {
+		round, _ := item.(bson.M)
+		roundStories, _ := round["stories"].(bson.A)
+		stories = append(stories, roundStories...)
+	}
+	for _, item := range stories {
+		if story, ok := item.(bson.M); ok {
+			add(story["authorid"])
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+	doc["members"] = members
+	return true, nil
+}
+
+func bsonTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().UTC(), true
+	case time.Time:
+		return v, true
+	}
+	return time.Time{}, false
+}
+
+type mongoMigrationStore struct {
+	db *mongo.Database
+}
+
+func (s *mongoMigrationStore) Documents(collection string, version int) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{
+		{"schemaversion": bson.M{"$lt": version}},
+		{"schemaversion": bson.M{"$exists": false}},
+	}}
+
+	cursor, err := s.db.Collection(collection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (s *mongoMigrationStore) ReplaceDocument(collection string, doc bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Collection(collection).ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", errMigrationConflict, err)
+	}
+	return err
+}
+
+func (s *mongoMigrationStore) AppliedMigrations() (map[int]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.db.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+func (s *mongoMigrationStore) RecordMigration(record migrationRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Collection("migrations").UpdateOne(
+		ctx,
+		bson.M{"version": record.Version},
+		bson.M{"$set": record},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// memoryMigrationStore keeps documents in memory. It is used to try migrations
+// without a database.
+type memoryMigrationStore struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+	records     []migrationRecord
+}
+
+func newMemoryMigrationStore() *memoryMigrationStore {
+	return &memoryMigrationStore{collections: make(map[string][]bson.M)}
+}
+
+func (s *memoryMigrationStore) Insert(collection string, doc bson.M) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections[collection] = append(s.collections[collection], doc)
+}
+
+func (s *memoryMigrationStore) Documents(collection string, version int) ([]bson.M, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var docs []bson.M
+	for _, doc := range s.collections[collection] {
+		if schemaVersion(doc) < version {
+			docs = append(docs, copyDocument(doc))
+		}
+	}
+	return docs, nil
+}
+
+func (s *memoryMigrationStore) ReplaceDocument(collection string, doc bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.collections[collection] {
+		if existing["_id"] == doc["_id"] {
+			s.collections[collection][i] = doc
+			return nil
+		}
+	}
+	return fmt.Errorf("dokument %v nie istnieje", doc["_id"])
+}
+
+func (s *memoryMigrationStore) AppliedMigrations() (map[int]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	applied := make(map[int]bool)
+	for _, record := range s.records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+func (s *memoryMigrationStore) RecordMigration(record migrationRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	sort.Slice(s.records, func(i, j int) bool { return s.records[i].Version < s.records[j].Version })
+	return nil
+}
+
+// dryRunMigrationStore keeps the upgraded documents in memory instead of
+// writing them, so that every migration of a dry run sees the documents as the
+// earlier ones left them. Conflicts are found by comparing the fields with a
+// unique index against the other documents of the collection.
+type dryRunMigrationStore struct {
+	migrationStore
+	collections map[string][]bson.M
+}
+
+func newDryRunMigrationStore(store migrationStore) *dryRunMigrationStore {
+	return &dryRunMigrationStore{migrationStore: store, collections: make(map[string][]bson.M)}
+}
+
+// load reads all documents of the collection from the real store once.
+func (s *dryRunMigrationStore) load(collection string) ([]bson.M, error) {
+	if docs, ok := s.collections[collection]; ok {
+		return docs, nil
+	}
+	docs, err := s.migrationStore.Documents(collection, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	s.collections[collection] = docs
+	return docs, nil
+}
+
+func (s *dryRunMigrationStore) Documents(collection string, version int) ([]bson.M, error) {
+	all, err := s.load(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.M
+	for _, doc := range all {
+		if schemaVersion(doc) < version {
+			docs = append(docs, copyDocument(doc))
+		}
+	}
+	return docs, nil
+}
+
+func (s *dryRunMigrationStore) ReplaceDocument(collection string, doc bson.M) error {
+	docs, err := s.load(collection)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, existing := range docs {
+		if existing["_id"] == doc["_id"] {
+			index = i
+			continue
+		}
+		for _, field := range uniqueFields(collection) {
+			value := doc[field]
+			if value != nil && value != "" && reflect.DeepEqual(existing[field], value) {
+				return fmt.Errorf("%w: %s %v jest już w dokumencie %v", errMigrationConflict, field, value, existing["_id"])
+			}
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("dokument %v nie istnieje", doc["_id"])
+	}
+	docs[index] = doc
+	return nil
+}
+
+func (s *dryRunMigrationStore) RecordMigration(migrationRecord) error {
+	return nil
+}
+
+// schemaVersion returns the version stored in the document, decoded by the
+// driver as int32 or set by a migration as int.
+func schemaVersion(doc bson.M) int {
+	switch v := doc["schemaversion"].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// copyDocument returns a deep copy, so that a dry run never changes the
+// stored documents.
+func copyDocument(doc bson.M) bson.M {
+	out := make(bson.M, len(doc))
+	for key, value := range doc {
+		out[key] = copyValue(value)
+	}
+	return out
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return copyDocument(v)
+	case bson.A:
+		out := make(bson.A, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func legacySession() bson.M {
+	return bson.M{
+		"_id":     "s1",
+		"id":      "session-1",
+		"players": bson.A{"Ala", "Jacek"},
+		"currentround": bson.M{
+			"id":           "round-2",
+			"activestory":  int32(1),
+			"user_stories": bson.A{"Logowanie", "Rejestracja"},
+			"tasks":        bson.M{"0": "Formularz", "5": "Usunięta story"},
+			"votes":        bson.M{"Ala": int32(5), "Jacek": int32(3)},
+		},
+		"roundhistory": bson.A{
+			bson.M{"id": "round-1", "votes": bson.M{"Ala": int32(8)}},
+		},
+	}
+}
+
+func TestRunMigrationsUpgradesLegacySessions(t *testing.T) {
+	store := newMemoryMigrationStore()
+	store.Insert("sessions", legacySession())
+	store.Insert("users", bson.M{"_id": "u1", "username": "  ALA "})
+
+	reports, err := runMigrations(store, migrations, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != len(migrations) || reports[0].Changed != 1 || reports[1].Changed != 1 || reports[2].Changed != 1 || reports[3].Changed != 1 {
+		t.Fatalf("nieoczekiwany raport: %v", reports)
+	}
+
+	session := store.collections["sessions"][0]
+	round := session["currentround"].(bson.M)
+	stories := round["stories"].(bson.A)
+	if len(stories) != 2 || stories[1].(bson.M)["title"] != "Rejestracja" {
+		t.Fatalf("story nie zostały zmigrowane: %v", stories)
+	}
+	storyID := stories[1].(bson.M)["id"].(string)
+	if round["activestory"] != storyID {
+		t.Errorf("oczekiwano aktywnej story %s, otrzymano %v", storyID, round["activestory"])
+	}
+
+	votes := round["votes"].(bson.M)
+	if story, ok := votes[storyID].(bson.M); !ok || story["Ala"] != int32(5) || story["Jacek"] != int32(3) {
+		t.Errorf("głosy bieżącej rundy nie zostały zmigrowane: %v", votes)
+	}
+	if _, ok := round["tasks"]; ok {
+		t.Errorf("mapa zadań nie została usunięta: %v", round["tasks"])
+	}
+	subtasks, _ := stories[0].(bson.M)["subtasks"].(bson.A)
+	if len(subtasks) != 1 || subtasks[0].(bson.M)["text"] != "Formularz" {
+		t.Errorf("zadania nie zostały zmigrowane: %v", stories[0])
+	}
+	if _, ok := stories[1].(bson.M)["subtasks"]; ok {
+		t.Errorf("nieoczekiwane zadania: %v", stories[1])
+	}
+
+	history := session["roundhistory"].(bson.A)[0].(bson.M)["votes"].(bson.M)
+	if story, ok := history[noStoryVotes].(bson.M); !ok || story["Ala"] != int32(8) {
+		t.Errorf("głosy historii nie zostały zmigrowane: %v", history)
+	}
+	if session["schemaversion"] != latestSchemaVersion("sessions") {
+		t.Errorf("oczekiwano schemaversion %d, otrzymano %v", latestSchemaVersion("sessions"), session["schemaversion"])
+	}
+
+	if key := store.collections["users"][0]["usernamekey"]; key != "ala" {
+		t.Errorf("oczekiwano klucza 'ala', otrzymano %v", key)
+	}
+
+	reports, err = runMigrations(store, migrations, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, report := range reports {
+		if !report.Applied {
+			t.Errorf("migracja %d powinna być oznaczona jako zastosowana", report.Version)
+		}
+	}
+}
+
+func TestRunMigrationsDryRun(t *testing.T) {
+	store := newMemoryMigrationStore()
+	store.Insert("sessions", legacySession())
+
+	reports, err := runMigrations(store, migrations, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each migration sees the session as the earlier ones left it, so the
+	// report matches a real run.
+	real := newMemoryMigrationStore()
+	real.Insert("sessions", legacySession())
+	expected, err := runMigrations(real, migrations, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("oczekiwano %d raportów, otrzymano %v", len(expected), reports)
+	}
+	for i, report := range reports {
+		if report.Checked != expected[i].Checked || report.Changed != expected[i].Changed || report.Conflicts != 0 {
+			t.Errorf("migracja %d: raport dry run %v, oczekiwano %v", report.Version, report, expected[i])
+		}
+	}
+	if reports[3].Changed != 1 {
+		t.Errorf("zadania przeniesione przez migrację 3 powinny zostać zmigrowane: %v", reports[3])
+	}
+
+	chained := []migration{
+		{Version: 1, Collection: "sessions", Up: func(doc bson.M) (bool, error) {
+			doc["stories"] = bson.A{}
+			return true, nil
+		}},
+		{Version: 2, Collection: "sessions", Up: func(doc bson.M) (bool, error) {
+			_, ok := doc["stories"]
+			return ok, nil
+		}},
+	}
+	reports, err = runMigrations(store, chained, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[1].Checked != 1 || reports[1].Changed != 1 {
+		t.Errorf("migracja 2 powinna widzieć zmiany migracji 1: %v", reports[1])
+	}
+
+	votes := store.collections["sessions"][0]["currentround"].(bson.M)["votes"].(bson.M)
+	if votes["Ala"] != int32(5) {
+		t.Errorf("dry run zmienił dokument: %v", votes)
+	}
+	if applied, _ := store.AppliedMigrations(); len(applied) != 0 {
+		t.Errorf("dry run zapisał migracje: %v", applied)
+	}
+}
+
+func TestRunMigrationsDryRunReportsConflicts(t *testing.T) {
+	store := newMemoryMigrationStore()
+	store.Insert("users", bson.M{"_id": "u1", "username": "Ala"})
+	store.Insert("users", bson.M{"_id": "u2", "username": " ala "})
+	store.Insert("users", bson.M{"_id": "u3", "username": "Jacek"})
+
+	reports, err := runMigrations(store, migrations, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reports[1]; r.Checked != 3 || r.Changed != 3 || r.Conflicts != 1 {
+		t.Errorf("oczekiwano jednego konfliktu klucza nazwy, otrzymano %v", r)
+	}
+	if _, ok := store.collections["users"][0]["usernamekey"]; ok {
+		t.Errorf("dry run zmienił dokument: %v", store.collections["users"][0])
+	}
+}
+
+func TestMigrateVotesPerStoryKeepsNewShape(t *testing.T) {
+	doc := bson.M{
+		"currentround": bson.M{
+			"votes": bson.M{"0": bson.M{"Ala": int32(5)}},
+		},
+	}
+
+	changed, err := migrateVotesPerStory(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("dokument w nowym formacie nie powinien się zmienić: %v", doc)
+	}
+}
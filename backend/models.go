@@ -2,27 +2,365 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+var errUserExists = errors.New("użytkownik już istnieje")
+
 type Session struct {
-	ID           string         `json:"id"`
-	Name         string         `json:"name"`
-	Players      []string       `json:"players"`
-	CurrentRound *Round         `json:"currentRound,omitempty"`
-	RoundHistory []*Round       `json:"roundHistory,omitempty"`
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Players      []string `json:"players"`
+	CurrentRound *Round   `json:"currentRound,omitempty"`
+	RoundHistory []*Round `json:"roundHistory,omitempty"`
+	// FacilitatorID is the user who created the session. Sessions created
+	// without logging in have no facilitator and can be run by anyone.
+	FacilitatorID string `json:"facilitatorId,omitempty"`
+	// Backlog holds the stories waiting to be pulled into a round.
+	Backlog []*Story `json:"backlog"`
+	// BacklogVersion changes whenever stories are added to or removed from
+	// the backlog.
+	BacklogVersion int             `json:"backlogVersion"`
+	Settings       SessionSettings `json:"settings"`
+	// TeamID links the session to the team whose issue tracker it uses.
+	TeamID string `json:"teamId,omitempty"`
+	// Notifier posts the session events to the team channel.
+	Notifier *ChatNotifier `json:"notifier,omitempty"`
+	// Members are the IDs of the logged in users who created or joined the
+	// session, used to list their sessions.
+	Members []string `json:"-"`
+	// Status is open, closed or archived; see lifecycle.go.
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	LastActivityAt time.Time  `json:"lastActivityAt"`
+	ClosedAt       *time.Time `json:"closedAt,omitempty"`
+	ArchivedAt     *time.Time `json:"archivedAt,omitempty"`
+	SchemaVersion  int        `json:"-" bson:"schemaversion"`
 }
 
 type Round struct {
-    ID          string            `json:"id"`
-    // Votes       map[string]int    `json:"votes"`
-	Votes map[int]map[string]int  `json:"votes"`
-	User_stories []string       `json:"user_stories"`
-    Tasks       map[int]string    `json:"tasks,omitempty"`
-    ActiveStory int               `json:"active_story"`
+	ID string `json:"id"`
+	// Votes maps a story ID to the votes of every player for that story.
+	Votes       map[string]map[string]int `json:"votes"`
+	Stories     []*Story                  `json:"stories"`
+	ActiveStory string                    `json:"active_story"`
+	// StoriesVersion changes whenever the stories are added, removed,
+	// reordered or edited.
+	StoriesVersion int       `json:"storiesVersion"`
+	StartedAt      time.Time `json:"startedAt"`
+	// VoteEvents is the audit trail of the round: every vote, retraction and
+	// opening of voting on a story, in the order they happened.
+	VoteEvents []*VoteEvent `json:"voteEvents,omitempty"`
+	// Anonymous is copied from the session settings when the round starts.
+	Anonymous bool `json:"anonymous"`
+	// Pseudonyms maps players to the names shown in an anonymous round.
+	Pseudonyms         map[string]string `json:"-"`
+	AttributionRemoved bool              `json:"-"`
+	// VoteStats is computed for the round details endpoint and never stored.
+	VoteStats []*playerVoteStats `json:"voteStats,omitempty" bson:"-"`
+}
+
+const (
+	voteEventOpened  = "opened"
+	voteEventVoted   = "voted"
+	voteEventRetract = "retracted"
+	// voteEventArchived marks the votes for the story archived as an
+	// iteration by a re-vote; later votes belong to the next iteration.
+	voteEventArchived = "archived"
+)
+
+type VoteEvent struct {
+	Type    string `json:"type"`
+	StoryID string `json:"storyId"`
+	Player  string `json:"player,omitempty"`
+	// Value is only set for votes.
+	Value *int      `json:"value,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// MarshalJSON adds the total of story points committed in the round.
+func (r *Round) MarshalJSON() ([]byte, error) {
+	type round Round
+	return json.Marshal(struct {
+		*round
+		CommittedPoints int `json:"committedPoints"`
+	}{
+		round:           (*round)(r),
+		CommittedPoints: r.committedPoints(),
+	})
+}
+
+func (r *Round) committedPoints() int {
+	total := 0
+	for _, story := range r.Stories {
+		if story.FinalEstimate != nil {
+			total += *story.FinalEstimate
+		}
+	}
+	return total
+}
+
+// noStoryVotes is the Votes key used while the round has no stories yet.
+const noStoryVotes = "none"
+
+type Story struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Author      string    `json:"author,omitempty"`
+	AuthorID    string    `json:"authorId,omitempty"`
+	// ExternalKey is the key of the story in the issue tracker, e.g. "KP-12".
+	ExternalKey string `json:"externalKey,omitempty"`
+	// Version changes on every edit of the story.
+	Version  int        `json:"version"`
+	Subtasks []*Subtask `json:"subtasks,omitempty"`
+	// FinalEstimate is the value the team agreed on, set by the facilitator.
+	FinalEstimate *int   `json:"finalEstimate,omitempty"`
+	EstimateNote  string `json:"estimateNote,omitempty"`
+	// Iterations are the earlier votes on the story archived by a re-vote.
+	Iterations []*VotingIteration `json:"iterations,omitempty"`
+	// Outliers are the players asked to explain their vote after the reveal.
+	Outliers       []string         `json:"outliers,omitempty"`
+	Justifications []*Justification `json:"justifications,omitempty"`
+}
+
+// VotingIteration is one finished vote on a story. Iterations are numbered
+// from 1; the votes currently in the round belong to the next number.
+type VotingIteration struct {
+	Number     int            `json:"number"`
+	Votes      map[string]int `json:"votes"`
+	ArchivedAt time.Time      `json:"archivedAt"`
+}
+
+const (
+	storyEstimated   = "estimated"
+	storyUnestimated = "unestimated"
+)
+
+type Subtask struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Assignee string `json:"assignee,omitempty"`
+	// Hours is the optional estimate of the subtask in hours.
+	Hours *float64 `json:"hours,omitempty"`
+	Done  bool     `json:"done"`
+}
+
+// MarshalJSON adds the estimation status and the roll-up of subtask hours
+// shown next to the story points.
+func (s *Story) MarshalJSON() ([]byte, error) {
+	type story Story
+	return json.Marshal(struct {
+		*story
+		Status       string  `json:"status"`
+		SubtaskHours float64 `json:"subtaskHours"`
+	}{
+		story:        (*story)(s),
+		Status:       s.status(),
+		SubtaskHours: s.subtaskHours(),
+	})
+}
+
+func (s *Story) status() string {
+	if s.FinalEstimate != nil {
+		return storyEstimated
+	}
+	return storyUnestimated
+}
+
+func (s *Story) subtaskHours() float64 {
+	total := 0.0
+	for _, subtask := range s.Subtasks {
+		if subtask.Hours != nil {
+			total += *subtask.Hours
+		}
+	}
+	return total
+}
+
+// archiveVotes stores the votes as the next iteration of the story.
+func (s *Story) archiveVotes(votes map[string]int) *VotingIteration {
+	archived := make(map[string]int, len(votes))
+	for player, value := range votes {
+		archived[player] = value
+	}
+
+	iteration := &VotingIteration{
+		Number:     len(s.Iterations) + 1,
+		Votes:      archived,
+		ArchivedAt: time.Now().UTC(),
+	}
+	s.Iterations = append(s.Iterations, iteration)
+	return iteration
+}
+
+// clone copies the story together with everything removeAttribution and the
+// story handlers change in place, so that the copy can change on its own.
+func (s *Story) clone() *Story {
+	story := *s
+	story.Subtasks = make([]*Subtask, len(s.Subtasks))
+	for i, subtask := range s.Subtasks {
+		copied := *subtask
+		story.Subtasks[i] = &copied
+	}
+	story.Iterations = make([]*VotingIteration, len(s.Iterations))
+	for i, iteration := range s.Iterations {
+		copied := *iteration
+		copied.Votes = make(map[string]int, len(iteration.Votes))
+		for player, value := range iteration.Votes {
+			copied.Votes[player] = value
+		}
+		story.Iterations[i] = &copied
+	}
+	story.Outliers = append([]string(nil), s.Outliers...)
+	story.Justifications = make([]*Justification, len(s.Justifications))
+	for i, justification := range s.Justifications {
+		copied := *justification
+		story.Justifications[i] = &copied
+	}
+	return &story
+}
+
+func (s *Story) findSubtask(subtaskID string) (int, *Subtask) {
+	for i, subtask := range s.Subtasks {
+		if subtask.ID == subtaskID {
+			return i, subtask
+		}
+	}
+	return -1, nil
+}
+
+func newStory(title, description, author string) *Story {
+	return &Story{
+		ID:          uuid.New().String(),
+		Title:       title,
+		Description: description,
+		CreatedAt:   time.Now().UTC(),
+		Author:      author,
+	}
+}
+
+func (r *Round) storyIndex(storyID string) int {
+	for i, story := range r.Stories {
+		if story.ID == storyID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Round) findStory(storyID string) *Story {
+	if i := r.storyIndex(storyID); i >= 0 {
+		return r.Stories[i]
+	}
+	return nil
+}
+
+// removeStory takes the story out of the round together with its votes and
+// moves the active story to the first remaining one if needed.
+func (r *Round) removeStory(storyID string) *Story {
+	index := r.storyIndex(storyID)
+	if index < 0 {
+		return nil
+	}
+
+	story := r.Stories[index]
+	r.Stories = append(r.Stories[:index], r.Stories[index+1:]...)
+	r.StoriesVersion++
+	delete(r.Votes, storyID)
+
+	if r.ActiveStory == storyID {
+		r.ActiveStory = ""
+		if len(r.Stories) > 0 {
+			r.setActiveStory(r.Stories[0].ID)
+		}
+	}
+
+	return story
+}
+
+// unestimatedStories returns copies of the stories of the round the team did
+// not agree on. They are carried over to the next round, which must not change
+// the stories of the round it came from.
+func (r *Round) unestimatedStories() []*Story {
+	stories := []*Story{}
+	for _, story := range r.Stories {
+		if story.FinalEstimate == nil {
+			stories = append(stories, story.clone())
+		}
+	}
+	return stories
+}
+
+func (s *Session) backlogIndex(storyID string) int {
+	for i, story := range s.Backlog {
+		if story.ID == storyID {
+			return i
+		}
+	}
+	return -1
+}
+
+// setActiveStory makes the story the one being voted on and records the
+// moment voting on it opened.
+func (r *Round) setActiveStory(storyID string) {
+	r.ActiveStory = storyID
+	r.recordVoteEvent(voteEventOpened, "", nil)
+}
+
+// vote stores the vote of the player for the active story.
+func (r *Round) vote(player string, value int) {
+	r.activeVotes()[player] = value
+	r.recordVoteEvent(voteEventVoted, player, &value)
+}
+
+// retractVote removes the vote of the player for the active story.
+func (r *Round) retractVote(player string) bool {
+	votes := r.activeVotes()
+	if _, ok := votes[player]; !ok {
+		return false
+	}
+	delete(votes, player)
+	r.recordVoteEvent(voteEventRetract, player, nil)
+	return true
+}
+
+func (r *Round) recordVoteEvent(eventType, player string, value *int) {
+	storyID := r.ActiveStory
+	if storyID == "" {
+		storyID = noStoryVotes
+	}
+	r.VoteEvents = append(r.VoteEvents, &VoteEvent{
+		Type:    eventType,
+		StoryID: storyID,
+		Player:  player,
+		Value:   value,
+		At:      time.Now().UTC(),
+	})
+}
+
+// activeVotes returns the votes cast for the active story.
+func (r *Round) activeVotes() map[string]int {
+	key := r.ActiveStory
+	if key == "" {
+		key = noStoryVotes
+	}
+	if r.Votes == nil {
+		r.Votes = make(map[string]map[string]int)
+	}
+	if r.Votes[key] == nil {
+		r.Votes[key] = make(map[string]int)
+	}
+	return r.Votes[key]
 }
 
 type User struct {
@@ -32,18 +370,133 @@ type User struct {
 	Token     string `json:"token,omitempty"`
 	TokenTime int64  `json:"token_time,omitempty"`
 	Avatar    string `json:"avatar" bson:"avatar"`
+	Email     string `json:"email,omitempty" bson:"email,omitempty"`
+	// UsernameKey is the normalized, case-folded username used for lookups.
+	UsernameKey   string `json:"-" bson:"usernamekey"`
+	SchemaVersion int    `json:"-" bson:"schemaversion"`
 }
 
+// sessionStatusFields are changed only by saveSessionStatus, so that saving a
+// session read before it was closed does not open it again.
+var sessionStatusFields = []string{"status", "closedat", "archivedat"}
+
+// sessionUpdate returns the update saving the session. The status is written
+// only when the session is created, and so is the last activity unless the
+// save records an action of the participants.
+func sessionUpdate(session *Session, activity bool) (bson.M, error) {
+	session.SchemaVersion = latestSchemaVersion("sessions")
+	if activity {
+		session.LastActivityAt = time.Now().UTC()
+	}
+
+	data, err := bson.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	insertOnly := sessionStatusFields
+	if !activity {
+		insertOnly = append(insertOnly[:len(insertOnly):len(insertOnly)], "lastactivityat")
+	}
+	onInsert := bson.M{}
+	for _, field := range insertOnly {
+		onInsert[field] = set[field]
+		delete(set, field)
+	}
+	return bson.M{"$set": set, "$setOnInsert": onInsert}, nil
+}
+
+// saveSession saves changes that are not made by the participants, like
+// settings, so that the session does not look active because of them.
 func saveSession(session *Session) error {
+	return upsertSession(session, false)
+}
+
+// saveSessionActivity saves an action of the participants (joining, voting,
+// rounds, stories) and records it as the last activity of the session.
+func saveSessionActivity(session *Session) error {
+	return upsertSession(session, true)
+}
+
+func upsertSession(session *Session, activity bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	update, err := sessionUpdate(session, activity)
+	if err != nil {
+		return err
+	}
+
 	filter := bson.M{"id": session.ID}
-	update := bson.M{"$set": session}
-	_, err := sessionCol.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	_, err = sessionCol.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	return err
 }
 
+// saveSessionIf saves the session only when the stored document still
+// matches condition. Activity tells whether the change is an action of the
+// participants. It reports false when the document was changed in the
+// meantime.
+func saveSessionIf(session *Session, condition bson.M, activity bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update, err := sessionUpdate(session, activity)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.M{"id": session.ID}
+	for key, value := range condition {
+		filter[key] = value
+	}
+
+	result, err := sessionCol.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// saveSessionStatus saves the status of the session when the stored document
+// matches condition, leaving the rest of it alone.
+func saveSessionStatus(session *Session, condition bson.M) (bool, error) {
+	set := bson.M{"status": session.Status, "closedat": session.ClosedAt, "archivedat": session.ArchivedAt}
+	if session.isOpen() {
+		// A reopened session starts a new period of activity, or the sweeper
+		// would close it again right away.
+		set["lastactivityat"] = session.LastActivityAt
+	}
+	return updateSessionFields(session.ID, condition, bson.M{"$set": set})
+}
+
+// updateSessionFields applies a targeted update to the session matching
+// condition, keeping the concurrent changes of the rest of the document. It
+// reports false when no session matched.
+func updateSessionFields(sessionID string, condition bson.M, update interface{}, arrayFilters ...interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"id": sessionID}
+	for key, value := range condition {
+		filter[key] = value
+	}
+
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+
+	result, err := sessionCol.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
 func getSession(id string) (*Session, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -61,22 +514,28 @@ func saveUser(user *User) error {
 	defer cancel()
 
 	user.Password = hashPassword(user.Password)
+	user.UsernameKey = usernameKey(user.Username)
+	user.SchemaVersion = latestSchemaVersion("users")
 
-	var existingUser User
-	err := userCol.FindOne(ctx, bson.M{"username": user.Username}).Decode(&existingUser)
-	if err == nil {
-		return fmt.Errorf("użytkownik już istnieje")
+	// Uniqueness is guaranteed by the indexes created in ensureIndexes.
+	_, err := userCol.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return errUserExists
 	}
-
-	_, err = userCol.InsertOne(ctx, user)
 	return err
 }
 func getUserByUsername(username string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Accounts created before usernames were normalized have no key yet.
+	filter := bson.M{"$or": []bson.M{
+		{"usernamekey": usernameKey(username)},
+		{"username": username},
+	}}
+
 	var user User
-	err := userCol.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	err := userCol.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return nil, fmt.Errorf("użytkownik nie znaleziony: %w", err)
 	}
@@ -117,6 +576,26 @@ func getUserByID(userID string) (*User, error) {
 	return &user, nil
 }
 
+// getUsersByIDs returns the users with the given IDs; unknown IDs are skipped.
+func getUsersByIDs(userIDs []string) ([]*User, error) {
+	users := []*User{}
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := userCol.Find(ctx, bson.M{"id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("błąd przy pobieraniu użytkowników: %w", err)
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("błąd przy odczycie użytkowników: %w", err)
+	}
+	return users, nil
+}
+
 func updateUserAvatar(userID, avatar string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -137,3 +616,286 @@ func updateUserAvatar(userID, avatar string) error {
 
 	return nil
 }
+
+// getSessionsByUser returns the sessions the user joined or added stories to.
+func getSessionsByUser(user *User) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{
+		{"players": user.Username},
+		{"members": user.ID},
+		{"currentround.stories.authorid": user.ID},
+		{"roundhistory.stories.authorid": user.ID},
+		{"backlog.authorid": user.ID},
+	}}
+
+	cursor, err := sessionCol.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("błąd przy pobieraniu sesji: %w", err)
+	}
+
+	var sessions []*Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("błąd przy odczycie sesji: %w", err)
+	}
+	return sessions, nil
+}
+
+func updateUserPassword(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCol.UpdateOne(
+		ctx,
+		bson.M{"id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"password":   user.Password,
+				"token":      user.Token,
+				"token_time": user.TokenTime,
+			},
+		},
+	)
+
+	if err != nil {
+		return fmt.Errorf("błąd podczas zmiany hasła: %w", err)
+	}
+
+	return nil
+}
+
+func updateUsername(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCol.UpdateOne(
+		ctx,
+		bson.M{"id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"username":    user.Username,
+				"usernamekey": usernameKey(user.Username),
+				"token":       user.Token,
+				"token_time":  user.TokenTime,
+			},
+		},
+	)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return errUserExists
+	}
+	if err != nil {
+		return fmt.Errorf("błąd podczas zmiany nazwy użytkownika: %w", err)
+	}
+
+	return nil
+}
+
+func deleteUser(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCol.DeleteOne(ctx, bson.M{"id": userID})
+	if err != nil {
+		return fmt.Errorf("błąd podczas usuwania użytkownika: %w", err)
+	}
+
+	_, err = resetCol.DeleteMany(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return fmt.Errorf("błąd podczas usuwania resetów hasła: %w", err)
+	}
+
+	if _, err := chatLinkCol.DeleteMany(ctx, bson.M{"userid": userID}); err != nil {
+		return fmt.Errorf("błąd podczas usuwania połączeń z czatem: %w", err)
+	}
+	if _, err := chatLinkCodeCol.DeleteMany(ctx, bson.M{"userid": userID}); err != nil {
+		return fmt.Errorf("błąd podczas usuwania kodów czatu: %w", err)
+	}
+
+	return nil
+}
+
+func updateUserEmail(userID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCol.UpdateOne(
+		ctx,
+		bson.M{"id": userID},
+		bson.M{
+			"$set": bson.M{
+				"email": email,
+			},
+		},
+	)
+
+	if err != nil {
+		return fmt.Errorf("błąd podczas aktualizacji adresu e-mail: %w", err)
+	}
+
+	return nil
+}
+
+func savePasswordReset(reset *PasswordReset) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Only the most recent link stays valid.
+	_, err := resetCol.UpdateMany(
+		ctx,
+		bson.M{"userid": reset.UserID, "used": false},
+		bson.M{"$set": bson.M{"used": true}},
+	)
+	if err != nil {
+		return fmt.Errorf("błąd przy unieważnianiu resetów hasła: %w", err)
+	}
+
+	_, err = resetCol.InsertOne(ctx, reset)
+	return err
+}
+
+// findPasswordReset returns the matching unused, unexpired reset without
+// using it up.
+func findPasswordReset(tokenHash string) (*PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var reset PasswordReset
+	err := resetCol.FindOne(
+		ctx,
+		bson.M{
+			"tokenhash": tokenHash,
+			"used":      false,
+			"expiresat": bson.M{"$gt": time.Now()},
+		},
+	).Decode(&reset)
+	if err != nil {
+		return nil, fmt.Errorf("reset hasła nie znaleziony: %w", err)
+	}
+	return &reset, nil
+}
+
+// consumePasswordReset marks the matching unused, unexpired reset as used and
+// returns it. The check and the update are a single operation, so a token can
+// be redeemed only once.
+func consumePasswordReset(tokenHash string) (*PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var reset PasswordReset
+	err := resetCol.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"tokenhash": tokenHash,
+			"used":      false,
+			"expiresat": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$set": bson.M{"used": true}},
+	).Decode(&reset)
+	if err != nil {
+		return nil, fmt.Errorf("reset hasła nie znaleziony: %w", err)
+	}
+	return &reset, nil
+}
+
+func saveTeam(team *Team) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := teamCol.InsertOne(ctx, team)
+	return err
+}
+
+func getTeam(id string) (*Team, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var team Team
+	err := teamCol.FindOne(ctx, bson.M{"id": id}).Decode(&team)
+	if err != nil {
+		return nil, fmt.Errorf("zespół nie znaleziony: %w", err)
+	}
+	return &team, nil
+}
+
+func updateTeamTracker(team *Team) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := teamCol.UpdateOne(
+		ctx,
+		bson.M{"id": team.ID},
+		bson.M{"$set": bson.M{"tracker": team.Tracker}},
+	)
+	return err
+}
+
+func updateTeamInbound(team *Team) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := teamCol.UpdateOne(
+		ctx,
+		bson.M{"id": team.ID},
+		bson.M{"$set": bson.M{"inbound": team.Inbound}},
+	)
+	return err
+}
+
+func saveChatLinkCode(code *chatLinkCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := chatLinkCodeCol.InsertOne(ctx, code)
+	return err
+}
+
+// useChatLinkCode deletes the unexpired code and returns the user it belongs
+// to, so that a code links only one chat account.
+func useChatLinkCode(code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var linkCode chatLinkCode
+	err := chatLinkCodeCol.FindOneAndDelete(
+		ctx,
+		bson.M{
+			"codehash":  hashResetToken(code),
+			"expiresat": bson.M{"$gt": time.Now()},
+		},
+	).Decode(&linkCode)
+	if err != nil {
+		return "", fmt.Errorf("kod nie znaleziony: %w", err)
+	}
+	return linkCode.UserID, nil
+}
+
+func saveChatLink(link *ChatLink) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := chatLinkCol.ReplaceOne(ctx, bson.M{"id": link.ID}, link, options.Replace().SetUpsert(true))
+	return err
+}
+
+func getChatLink(id string) (*ChatLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var link ChatLink
+	if err := chatLinkCol.FindOne(ctx, bson.M{"id": id}).Decode(&link); err != nil {
+		return nil, fmt.Errorf("konto czatu nie jest połączone: %w", err)
+	}
+	return &link, nil
+}
+
+// addMember records the user as a member of the session once.
+func (s *Session) addMember(userID string) {
+	for _, id := range s.Members {
+		if id == userID {
+			return
+		}
+	}
+	s.Members = append(s.Members, userID)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSessionPageSize = 20
+	maxSessionPageSize     = 100
+)
+
+// sessionSortFields maps the sort parameter to the stored field.
+var sessionSortFields = map[string]string{
+	"lastActivity": "lastactivityat",
+	"created":      "createdat",
+	"name":         "name",
+}
+
+// sessionListParams are the query parameters of GET /me/sessions.
+type sessionListParams struct {
+	Page     int
+	PageSize int
+	// Sort is a key of sessionSortFields, or "relevance" for searches.
+	Sort   string
+	Desc   bool
+	Query  string
+	Status string
+}
+
+func parseSessionListParams(values url.Values) (sessionListParams, error) {
+	params := sessionListParams{
+		Page:     1,
+		PageSize: defaultSessionPageSize,
+		Query:    strings.TrimSpace(values.Get("q")),
+		Status:   values.Get("status"),
+	}
+
+	if page := values.Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return params, fmt.Errorf("nieprawidłowy numer strony: %q", page)
+		}
+		params.Page = n
+	}
+
+	if size := values.Get("pageSize"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 || n > maxSessionPageSize {
+			return params, fmt.Errorf("rozmiar strony musi być liczbą od 1 do %d", maxSessionPageSize)
+		}
+		params.PageSize = n
+	}
+
+	switch params.Status {
+	case "", sessionOpen, sessionClosed, sessionArchived:
+	default:
+		return params, fmt.Errorf("nieznany status sesji: %q", params.Status)
+	}
+
+	params.Sort = values.Get("sort")
+	if params.Sort == "" {
+		params.Sort = "lastActivity"
+		if params.Query != "" {
+			params.Sort = "relevance"
+		}
+	}
+	if _, ok := sessionSortFields[params.Sort]; !ok && (params.Sort != "relevance" || params.Query == "") {
+		return params, fmt.Errorf("nieznane sortowanie: %q", params.Sort)
+	}
+
+	switch values.Get("order") {
+	case "":
+		// Names read naturally A to Z, dates newest first.
+		params.Desc = params.Sort != "name"
+	case "asc":
+	case "desc":
+		params.Desc = true
+	default:
+		return params, fmt.Errorf("nieznana kolejność: %q", values.Get("order"))
+	}
+
+	return params, nil
+}
+
+// mySessionsFilter matches the sessions the user created or joined. Player
+// names are not matched, since guests can type any name.
+func mySessionsFilter(user *User, params sessionListParams) bson.M {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"members": user.ID},
+		bson.M{"facilitatorid": user.ID},
+	}}
+
+	if params.Status == sessionOpen {
+		// Sessions saved before the status was introduced are open.
+		filter["status"] = openStatusFilter()
+	} else if params.Status != "" {
+		filter["status"] = params.Status
+	}
+
+	if params.Query != "" {
+		filter["$text"] = bson.M{"$search": params.Query}
+	}
+	return filter
+}
+
+func mySessionsFindOptions(params sessionListParams) *options.FindOptions {
+	// Only the fields of the list are read, not the rounds.
+	projection := bson.M{
+		"id":             1,
+		"name":           1,
+		"status":         1,
+		"facilitatorid":  1,
+		"players":        1,
+		"createdat":      1,
+		"lastactivityat": 1,
+	}
+	if params.Query != "" {
+		projection["score"] = bson.M{"$meta": "textScore"}
+	}
+
+	opts := options.Find().
+		SetSkip(int64((params.Page - 1) * params.PageSize)).
+		SetLimit(int64(params.PageSize)).
+		SetProjection(projection)
+
+	if params.Sort == "relevance" {
+		return opts.SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "id", Value: 1}})
+	}
+
+	direction := 1
+	if params.Desc {
+		direction = -1
+	}
+	// The ID keeps the order of equal values stable between pages.
+	return opts.SetSort(bson.D{{Key: sessionSortFields[params.Sort], Value: direction}, {Key: "id", Value: direction}})
+}
+
+// sessionListItem is a session in the list, without its rounds.
+type sessionListItem struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Role is "facilitator" or "member".
+	Role           string    `json:"role"`
+	Participants   int       `json:"participants"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+func newSessionListItem(session *Session, user *User) *sessionListItem {
+	item := &sessionListItem{
+		ID:             session.ID,
+		Name:           session.Name,
+		Status:         session.Status,
+		Role:           "member",
+		Participants:   len(session.Players),
+		CreatedAt:      session.CreatedAt,
+		LastActivityAt: session.LastActivityAt,
+	}
+	if item.Status == "" {
+		item.Status = sessionOpen
+	}
+	if session.FacilitatorID == user.ID {
+		item.Role = "facilitator"
+	}
+	return item
+}
+
+func listMySessions(user *User, params sessionListParams) ([]*sessionListItem, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := mySessionsFilter(user, params)
+	total, err := sessionCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("błąd przy liczeniu sesji: %w", err)
+	}
+
+	cursor, err := sessionCol.Find(ctx, filter, mySessionsFindOptions(params))
+	if err != nil {
+		return nil, 0, fmt.Errorf("błąd przy pobieraniu sesji: %w", err)
+	}
+
+	var sessions []*Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, 0, fmt.Errorf("błąd przy odczycie sesji: %w", err)
+	}
+
+	items := make([]*sessionListItem, 0, len(sessions))
+	for _, session := range sessions {
+		items = append(items, newSessionListItem(session, user))
+	}
+	return items, total, nil
+}
+
+func mySessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := parseSessionListParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessions, total, err := listMySessions(user, params)
+	if err != nil {
+		http.Error(w, "Błąd przy pobieraniu sesji", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Sessions []*sessionListItem `json:"sessions"`
+		Page     int                `json:"page"`
+		PageSize int                `json:"pageSize"`
+		Total    int64              `json:"total"`
+	}{sessions, params.Page, params.PageSize, total})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseSessionListParams(t *testing.T) {
+	params, err := parseSessionListParams(url.Values{})
+	if err != nil {
+		t.Fatalf("Błąd dla domyślnych parametrów: %v", err)
+	}
+	if params.Page != 1 || params.PageSize != defaultSessionPageSize || params.Sort != "lastActivity" || !params.Desc {
+		t.Errorf("Nieprawidłowe domyślne parametry: %+v", params)
+	}
+
+	params, err = parseSessionListParams(url.Values{"q": {" sprint "}, "page": {"3"}, "pageSize": {"50"}})
+	if err != nil {
+		t.Fatalf("Błąd dla wyszukiwania: %v", err)
+	}
+	if params.Query != "sprint" || params.Sort != "relevance" || params.Page != 3 || params.PageSize != 50 {
+		t.Errorf("Nieprawidłowe parametry wyszukiwania: %+v", params)
+	}
+
+	params, err = parseSessionListParams(url.Values{"sort": {"name"}})
+	if err != nil || params.Desc {
+		t.Errorf("Nazwy powinny być domyślnie sortowane rosnąco: %+v, %v", params, err)
+	}
+
+	invalid := []url.Values{
+		{"page": {"0"}},
+		{"pageSize": {"1000"}},
+		{"sort": {"players"}},
+		{"sort": {"relevance"}},
+		{"order": {"up"}},
+		{"status": {"deleted"}},
+	}
+	for _, values := range invalid {
+		if _, err := parseSessionListParams(values); err == nil {
+			t.Errorf("Oczekiwano błędu dla %v", values)
+		}
+	}
+}
+
+func TestMySessionsFilter(t *testing.T) {
+	user := &User{ID: "u1", Username: "ala"}
+
+	filter := mySessionsFilter(user, sessionListParams{Query: "sprint", Status: sessionClosed})
+	if len(filter["$or"].(bson.A)) != 2 {
+		t.Errorf("Filtr powinien szukać po członkach i prowadzącym: %v", filter)
+	}
+	for _, condition := range filter["$or"].(bson.A) {
+		if _, ok := condition.(bson.M)["players"]; ok {
+			t.Errorf("Filtr nie powinien szukać po nazwie gracza: %v", filter)
+		}
+	}
+	if filter["status"] != sessionClosed {
+		t.Errorf("Nieprawidłowy filtr statusu: %v", filter["status"])
+	}
+	if filter["$text"].(bson.M)["$search"] != "sprint" {
+		t.Errorf("Brak wyszukiwania tekstowego: %v", filter)
+	}
+
+	if filter := mySessionsFilter(user, sessionListParams{}); filter["$text"] != nil || filter["status"] != nil {
+		t.Errorf("Filtr bez parametrów nie powinien zawężać wyników: %v", filter)
+	}
+}
+
+func TestMySessionsFindOptions(t *testing.T) {
+	opts := mySessionsFindOptions(sessionListParams{Page: 3, PageSize: 10, Sort: "created", Desc: true})
+	if *opts.Skip != 20 || *opts.Limit != 10 {
+		t.Errorf("Nieprawidłowe stronicowanie: pominięto %d, limit %d", *opts.Skip, *opts.Limit)
+	}
+	sort := opts.Sort.(bson.D)
+	if sort[0].Key != "createdat" || sort[0].Value != -1 {
+		t.Errorf("Nieprawidłowe sortowanie: %v", sort)
+	}
+
+	opts = mySessionsFindOptions(sessionListParams{Page: 1, PageSize: 10, Sort: "relevance", Query: "sprint"})
+	if sort := opts.Sort.(bson.D); sort[0].Key != "score" {
+		t.Errorf("Wyszukiwanie powinno być sortowane po trafności: %v", sort)
+	}
+	if _, ok := opts.Projection.(bson.M)["score"]; !ok {
+		t.Errorf("Brak wyniku trafności w projekcji")
+	}
+}
+
+func TestNewSessionListItem(t *testing.T) {
+	user := &User{ID: "u1", Username: "ala"}
+	session := &Session{ID: "session-1", Name: "Sprint 42", FacilitatorID: "u1", Players: []string{"ala", "jacek"}}
+
+	item := newSessionListItem(session, user)
+	if item.Role != "facilitator" || item.Participants != 2 || item.Status != sessionOpen {
+		t.Errorf("Nieprawidłowa pozycja listy: %+v", item)
+	}
+
+	session.FacilitatorID = "u2"
+	if item := newSessionListItem(session, user); item.Role != "member" {
+		t.Errorf("Oczekiwano roli członka, otrzymano %s", item.Role)
+	}
+}
+
+func TestSessionMembers(t *testing.T) {
+	session := &Session{}
+	prepareSession(session, "u1")
+	session.addMember("u2")
+	session.addMember("u1")
+	if len(session.Members) != 2 || session.Members[0] != "u1" || session.Members[1] != "u2" {
+		t.Fatalf("Nieprawidłowi członkowie: %v", session.Members)
+	}
+
+	if !anonymizeUserInSession(session, &User{ID: "u2", Username: "jacek"}, "usunięty-u2") {
+		t.Errorf("Usunięcie członka powinno zmienić sesję")
+	}
+	if len(session.Members) != 1 || session.Members[0] != "u1" {
+		t.Errorf("Usunięty użytkownik pozostał członkiem: %v", session.Members)
+	}
+}
+
+func TestMigrateSessionMembers(t *testing.T) {
+	doc := bson.M{
+		"facilitatorid": "u1",
+		"players":       bson.A{"ala", "jacek"},
+		"members":       bson.A{"u1"},
+		"backlog":       bson.A{bson.M{"title": "Eksport", "authorid": "u3"}},
+		"currentround":  bson.M{"stories": bson.A{bson.M{"title": "Logowanie", "authorid": "u2"}, bson.M{"title": "Gość"}}},
+		"roundhistory":  bson.A{bson.M{"stories": bson.A{bson.M{"title": "Rejestracja", "authorid": "u2"}}}},
+	}
+
+	changed, err := migrateSessionMembers(doc)
+	if err != nil || !changed {
+		t.Fatalf("Dokument powinien się zmienić: %v", err)
+	}
+	members := doc["members"].(bson.A)
+	if len(members) != 3 || members[0] != "u1" || members[1] != "u3" || members[2] != "u2" {
+		t.Errorf("Nieprawidłowi członkowie: %v", members)
+	}
+
+	if changed, _ := migrateSessionMembers(doc); changed {
+		t.Errorf("Zmigrowany dokument nie powinien się zmienić")
+	}
+	if changed, _ := migrateSessionMembers(bson.M{"players": bson.A{"ala"}}); changed {
+		t.Errorf("Sesja gości nie powinna dostać członków")
+	}
+}
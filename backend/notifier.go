@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	chatFormatSlack      = "slack"
+	chatFormatMattermost = "mattermost"
+)
+
+// ChatNotifier posts session events to a Slack or Mattermost incoming webhook
+// of the team channel.
+type ChatNotifier struct {
+	Format string `json:"format"`
+	// WebhookURL contains the credentials of the channel, so it is stored but
+	// never sent back to clients.
+	WebhookURL string `json:"-"`
+	// Events are the event types posted to the channel.
+	Events []string `json:"events"`
+	// Templates override the default message of an event type.
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+var defaultChatEvents = []string{eventSessionStarted, eventSessionEnded}
+
+// defaultChatTemplates are written in Slack mrkdwn, where *x* is bold.
+var defaultChatTemplates = map[string]string{
+	eventSessionStarted: "Planowanie *{{.SessionName}}* właśnie się zaczęło. Dołącz: {{.JoinURL}}",
+	eventRoundStarted:   "Runda {{.Data.RoundID}} w sesji *{{.SessionName}}*: {{len .Data.Stories}} user stories do estymacji.",
+	eventStoryEstimated: "*{{.Data.Title}}* oszacowana na {{.Data.FinalEstimate}} SP w sesji *{{.SessionName}}*.",
+	eventSessionEnded: `Planowanie *{{.SessionName}}* zakończone: {{.Summary.Estimated}} z {{.Summary.Total}} user stories oszacowanych, razem {{.Summary.Points}} SP.
+{{range .Summary.Stories}}• {{.Title}}: {{if .FinalEstimate}}{{deref .FinalEstimate}} SP{{else}}bez estymaty{{end}}
+{{end}}`,
+}
+
+var chatTemplateFuncs = template.FuncMap{
+	"deref": func(value *int) int { return *value },
+}
+
+var (
+	slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	// mattermostEscaper escapes the Markdown that formats text or makes links.
+	mattermostEscaper = strings.NewReplacer(
+		`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`",
+		"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+	)
+)
+
+// slackEscape keeps text typed by users, like session names and story titles,
+// from turning into mentions (<!channel>) or links in Slack mrkdwn.
+func slackEscape(text string) string {
+	return slackEscaper.Replace(text)
+}
+
+// escape formats a value the way a template prints it and escapes it for the
+// chat.
+func (n *ChatNotifier) escape(value any) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	text := fmt.Sprint(value)
+	if v.IsValid() {
+		text = fmt.Sprint(v.Interface())
+	}
+
+	if n.Format == chatFormatMattermost {
+		return mattermostEscaper.Replace(text)
+	}
+	return slackEscape(text)
+}
+
+// escapeActions pipes every value printed by the template through
+// chatEscape, so custom templates are escaped as well as the default ones.
+func escapeActions(tree *parse.Tree, node parse.Node) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			escapeActions(tree, child)
+		}
+	case *parse.ActionNode:
+		// Variable declarations print nothing.
+		if len(node.Pipe.Decl) == 0 {
+			escape := parse.NewIdentifier("chatEscape").SetTree(tree).SetPos(node.Pos)
+			node.Pipe.Cmds = append(node.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: node.Pos, Args: []parse.Node{escape}})
+		}
+	case *parse.IfNode:
+		escapeActions(tree, node.List)
+		escapeActions(tree, node.ElseList)
+	case *parse.RangeNode:
+		escapeActions(tree, node.List)
+		escapeActions(tree, node.ElseList)
+	case *parse.WithNode:
+		escapeActions(tree, node.List)
+		escapeActions(tree, node.ElseList)
+	}
+}
+
+// chatMessageData is what the message templates see.
+type chatMessageData struct {
+	Event       string
+	SessionName string
+	JoinURL     string
+	// Data is the data of the event, e.g. storyEstimatedData.
+	Data any
+	// Summary is set for session.ended.
+	Summary *chatSessionSummary
+}
+
+type chatSessionSummary struct {
+	Stories   []*storyResultsRow
+	Total     int
+	Estimated int
+	Points    int
+}
+
+func newChatSessionSummary(session *Session) *chatSessionSummary {
+	summary := &chatSessionSummary{Stories: buildSessionResults(session).Stories}
+	summary.Total = len(summary.Stories)
+	for _, row := range summary.Stories {
+		if row.FinalEstimate != nil {
+			summary.Estimated++
+			summary.Points += *row.FinalEstimate
+		}
+	}
+	return summary
+}
+
+func (n *ChatNotifier) wants(eventType string) bool {
+	for _, t := range n.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *ChatNotifier) template(eventType string) (*template.Template, error) {
+	text, ok := n.Templates[eventType]
+	if !ok {
+		text = defaultChatTemplates[eventType]
+		if n.Format == chatFormatMattermost {
+			// Mattermost uses Markdown, where *x* is italic.
+			text = strings.ReplaceAll(text, "*", "**")
+		}
+	}
+
+	tmpl, err := template.New(eventType).
+		Funcs(chatTemplateFuncs).
+		Funcs(template.FuncMap{"chatEscape": n.escape}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		escapeActions(t.Tree, t.Tree.Root)
+	}
+	return tmpl, nil
+}
+
+// validate checks the settings before they are saved, including that every
+// template renders with sample data and that the webhook does not lead to the
+// internal network.
+func (n *ChatNotifier) validate(ctx context.Context) error {
+	if n.Format != chatFormatSlack && n.Format != chatFormatMattermost {
+		return fmt.Errorf("nieobsługiwany format powiadomień: %q", n.Format)
+	}
+
+	parsed, err := url.Parse(n.WebhookURL)
+	if err != nil || parsed.Scheme != "https" && parsed.Scheme != "http" || parsed.Host == "" {
+		return fmt.Errorf("podaj adres webhooka kanału")
+	}
+
+	for _, eventType := range n.Events {
+		if !validEventType(eventType) {
+			return fmt.Errorf("nieznany typ zdarzenia: %s", eventType)
+		}
+	}
+
+	sample := &Session{ID: "session-1", Name: "Sesja"}
+	for eventType := range n.Templates {
+		if !validEventType(eventType) {
+			return fmt.Errorf("nieznany typ zdarzenia: %s", eventType)
+		}
+		if _, err := n.render(sample, sampleEvent(eventType)); err != nil {
+			return fmt.Errorf("błędny szablon %s: %v", eventType, err)
+		}
+	}
+	return checkWebhookHost(ctx, parsed.Hostname())
+}
+
+func sampleEvent(eventType string) Event {
+	event := Event{Type: eventType, SessionID: "session-1", SessionName: "Sesja"}
+	switch eventType {
+	case eventRoundStarted:
+		event.Data = roundStartedData{RoundID: "round-1", Stories: []eventStoryRef{}}
+	case eventStoryEstimated:
+		event.Data = storyEstimatedData{RoundID: "round-1", StoryID: "story-1", Title: "Logowanie", FinalEstimate: 3}
+	}
+	return event
+}
+
+func (n *ChatNotifier) render(session *Session, event Event) (string, error) {
+	tmpl, err := n.template(event.Type)
+	if err != nil {
+		return "", err
+	}
+
+	data := chatMessageData{
+		Event:       event.Type,
+		SessionName: session.Name,
+		JoinURL:     frontendURL("/game/" + session.ID),
+		Data:        event.Data,
+	}
+	if event.Type == eventSessionEnded {
+		data.Summary = newChatSessionSummary(session)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// chatPayload builds the request body. Mattermost reads only the text; Slack
+// shows the blocks and uses the text in notifications.
+func (n *ChatNotifier) chatPayload(session *Session, eventType, text string) map[string]any {
+	payload := map[string]any{"text": text}
+	if n.Format != chatFormatSlack {
+		return payload
+	}
+
+	blocks := []map[string]any{{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": text},
+	}}
+	if eventType == eventSessionStarted || eventType == eventRoundStarted {
+		blocks = append(blocks, map[string]any{
+			"type": "actions",
+			"elements": []map[string]any{{
+				"type": "button",
+				"text": map[string]string{"type": "plain_text", "text": "Dołącz do sesji"},
+				"url":  frontendURL("/game/" + session.ID),
+			}},
+		})
+	}
+	payload["blocks"] = blocks
+	return payload
+}
+
+// chatHTTPClient refuses internal addresses like the webhook client, as the
+// channel URL is given by the facilitator.
+var chatHTTPClient = newWebhookClient()
+
+// notifyChat posts the event to the channel of the session when it is
+// configured for it.
+func notifyChat(ctx context.Context, client *http.Client, session *Session, event Event) error {
+	notifier := session.Notifier
+	if notifier == nil || !notifier.wants(event.Type) {
+		return nil
+	}
+
+	text, err := notifier.render(session, event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(notifier.chatPayload(session, event.Type, text))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("kanał odpowiedział %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// startChatNotifier posts the events of sessions with a configured channel.
+// The session is read again so that the summary includes the latest state.
+func startChatNotifier() {
+	subscribeEvents(func(event Event) {
+		session, err := getSession(event.SessionID)
+		if err != nil || session.Notifier == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if err := notifyChat(ctx, chatHTTPClient, session, event); err != nil {
+			log.Printf("Błąd przy wysyłaniu powiadomienia %s sesji %s: %v", event.Type, session.ID, err)
+		}
+	})
+}
+
+func updateNotifierHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ChatNotifier
+		// WebhookURL is write-only. An empty URL keeps the stored one.
+		WebhookURL string `json:"webhookUrl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	// The server posts to the given URL, so like webhooks it is only
+	// configured by a known facilitator.
+	if session.FacilitatorID == "" {
+		http.Error(w, "Powiadomienia na czacie są dostępne tylko w sesjach założonych przez zalogowanego prowadzącego", http.StatusForbidden)
+		return
+	}
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	notifier := payload.ChatNotifier
+	notifier.WebhookURL = payload.WebhookURL
+	if notifier.WebhookURL == "" && session.Notifier != nil {
+		notifier.WebhookURL = session.Notifier.WebhookURL
+	}
+	if notifier.Format == "" {
+		notifier.Format = chatFormatSlack
+	}
+	if notifier.Events == nil {
+		notifier.Events = defaultChatEvents
+	}
+
+	if err := notifier.validate(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session.Notifier = &notifier
+	update := bson.M{"$set": bson.M{"notifier": session.Notifier}}
+	if _, err := updateSessionFields(session.ID, nil, update); err != nil {
+		http.Error(w, "Błąd przy zapisie powiadomień", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(session.Notifier); err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+func deleteNotifierHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getSession(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	session.Notifier = nil
+	update := bson.M{"$set": bson.M{"notifier": nil}}
+	if _, err := updateSessionFields(session.ID, nil, update); err != nil {
+		http.Error(w, "Błąd przy zapisie powiadomień", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeChatEndpoint stands in for a Slack or Mattermost incoming webhook and
+// records the payloads it receives.
+func fakeChatEndpoint(t *testing.T, status int) (*httptest.Server, func() []map[string]any) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		payloads []map[string]any
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Nieprawidłowy JSON powiadomienia: %v", err)
+		}
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]any{}, payloads...)
+	}
+}
+
+func estimatedSession() *Session {
+	five := 5
+	return &Session{
+		ID:   "session-1",
+		Name: "Sprint 42",
+		CurrentRound: &Round{
+			ID: "round-1",
+			Stories: []*Story{
+				{ID: "s1", Title: "Logowanie", FinalEstimate: &five},
+				{ID: "s2", Title: "Rejestracja"},
+			},
+			Votes: map[string]map[string]int{},
+		},
+	}
+}
+
+func TestNotifyChatSlackSessionStarted(t *testing.T) {
+	server, received := fakeChatEndpoint(t, http.StatusOK)
+	session := estimatedSession()
+	session.Notifier = &ChatNotifier{Format: chatFormatSlack, WebhookURL: server.URL, Events: defaultChatEvents}
+
+	event := Event{Type: eventSessionStarted, SessionID: session.ID}
+	if err := notifyChat(context.Background(), server.Client(), session, event); err != nil {
+		t.Fatalf("Błąd wysyłania: %v", err)
+	}
+
+	payloads := received()
+	if len(payloads) != 1 {
+		t.Fatalf("Oczekiwano jednego powiadomienia, otrzymano %d", len(payloads))
+	}
+	text, _ := payloads[0]["text"].(string)
+	if !strings.Contains(text, "Sprint 42") || !strings.Contains(text, "/game/session-1") {
+		t.Errorf("Brak nazwy sesji lub linku: %q", text)
+	}
+	blocks, _ := payloads[0]["blocks"].([]any)
+	if len(blocks) != 2 {
+		t.Errorf("Oczekiwano sekcji i przycisku, otrzymano %v", payloads[0]["blocks"])
+	}
+}
+
+func TestNotifyChatMattermostSummary(t *testing.T) {
+	server, received := fakeChatEndpoint(t, http.StatusOK)
+	session := estimatedSession()
+	session.Notifier = &ChatNotifier{Format: chatFormatMattermost, WebhookURL: server.URL, Events: defaultChatEvents}
+
+	if err := notifyChat(context.Background(), server.Client(), session, Event{Type: eventSessionEnded}); err != nil {
+		t.Fatalf("Błąd wysyłania: %v", err)
+	}
+
+	payloads := received()
+	if len(payloads) != 1 {
+		t.Fatalf("Oczekiwano jednego powiadomienia, otrzymano %d", len(payloads))
+	}
+	if _, ok := payloads[0]["blocks"]; ok {
+		t.Errorf("Mattermost nie powinien dostać bloków Slacka")
+	}
+	text, _ := payloads[0]["text"].(string)
+	for _, want := range []string{"1 z 2", "razem 5 SP", "Logowanie: 5 SP", "Rejestracja: bez estymaty"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Podsumowanie nie zawiera %q: %q", want, text)
+		}
+	}
+}
+
+func TestNotifyChatSkipsUnwantedEvents(t *testing.T) {
+	server, received := fakeChatEndpoint(t, http.StatusOK)
+	session := estimatedSession()
+	session.Notifier = &ChatNotifier{Format: chatFormatSlack, WebhookURL: server.URL, Events: []string{eventSessionEnded}}
+
+	if err := notifyChat(context.Background(), server.Client(), session, Event{Type: eventRoundStarted}); err != nil {
+		t.Fatalf("Błąd wysyłania: %v", err)
+	}
+	if len(received()) != 0 {
+		t.Errorf("Wysłano powiadomienie o zdarzeniu spoza listy")
+	}
+}
+
+func TestNotifyChatCustomTemplateAndErrors(t *testing.T) {
+	server, received := fakeChatEndpoint(t, http.StatusInternalServerError)
+	session := estimatedSession()
+	session.Notifier = &ChatNotifier{
+		Format:     chatFormatMattermost,
+		WebhookURL: server.URL,
+		Events:     []string{eventStoryEstimated},
+		Templates:  map[string]string{eventStoryEstimated: "{{.Data.Title}} = {{.Data.FinalEstimate}}"},
+	}
+
+	event := Event{Type: eventStoryEstimated, Data: storyEstimatedData{Title: "Logowanie", FinalEstimate: 5}}
+	if err := notifyChat(context.Background(), server.Client(), session, event); err == nil {
+		t.Errorf("Oczekiwano błędu dla odpowiedzi 500")
+	}
+
+	payloads := received()
+	if len(payloads) != 1 || payloads[0]["text"] != "Logowanie = 5" {
+		t.Errorf("Nieprawidłowa treść z własnego szablonu: %v", payloads)
+	}
+}
+
+func TestChatNotifierValidate(t *testing.T) {
+	// An IP address is checked without looking up the host.
+	valid := ChatNotifier{Format: chatFormatSlack, WebhookURL: "https://93.184.216.34/services/x", Events: defaultChatEvents}
+	if err := valid.validate(context.Background()); err != nil {
+		t.Errorf("Poprawne ustawienia odrzucone: %v", err)
+	}
+
+	cases := map[string]ChatNotifier{
+		"format":    {Format: "teams", WebhookURL: valid.WebhookURL},
+		"adres":     {Format: chatFormatSlack, WebhookURL: "hooks.slack.com"},
+		"zdarzenie": {Format: chatFormatSlack, WebhookURL: valid.WebhookURL, Events: []string{"vote.cast"}},
+		"szablon":   {Format: chatFormatSlack, WebhookURL: valid.WebhookURL, Templates: map[string]string{eventSessionStarted: "{{.Brak}}"}},
+		"loopback":  {Format: chatFormatSlack, WebhookURL: "http://127.0.0.1:8080/hook"},
+		"metadane":  {Format: chatFormatSlack, WebhookURL: "http://169.254.169.254/latest/meta-data"},
+	}
+	for name, notifier := range cases {
+		if err := notifier.validate(context.Background()); err == nil {
+			t.Errorf("Oczekiwano błędu walidacji (%s)", name)
+		}
+	}
+}
+
+func TestChatNotifierEscapesUserText(t *testing.T) {
+	session := estimatedSession()
+	session.Name = "<!channel> Sprint & co"
+	event := Event{Type: eventStoryEstimated, Data: storyEstimatedData{Title: "<https://evil|Dołącz>", FinalEstimate: 5}}
+
+	slack := &ChatNotifier{Format: chatFormatSlack}
+	text, err := slack.render(session, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "*&lt;https://evil|Dołącz&gt;* oszacowana na 5 SP w sesji *&lt;!channel&gt; Sprint &amp; co*."
+	if text != want {
+		t.Errorf("Slack: oczekiwano %q, otrzymano %q", want, text)
+	}
+
+	custom := &ChatNotifier{Format: chatFormatSlack, Templates: map[string]string{
+		eventStoryEstimated: "{{with .Data}}{{$title := .Title}}{{$title}}{{end}}",
+	}}
+	if text, err := custom.render(session, event); err != nil || text != "&lt;https://evil|Dołącz&gt;" {
+		t.Errorf("Własny szablon nie jest escapowany: %q, %v", text, err)
+	}
+
+	mattermost := &ChatNotifier{Format: chatFormatMattermost}
+	text, err = mattermost.render(session, Event{Type: eventStoryEstimated, Data: storyEstimatedData{Title: "[Dołącz](https://evil) *pilne*", FinalEstimate: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `**\[Dołącz\](https://evil) \*pilne\*** oszacowana na 5 SP w sesji **\<!channel\> Sprint & co**.`
+	if text != want {
+		t.Errorf("Mattermost: oczekiwano %q, otrzymano %q", want, text)
+	}
+}
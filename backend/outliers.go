@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Rules for picking the players asked to explain their vote after a reveal.
+const (
+	// outlierRuleMinMax picks everyone who voted the lowest or the highest value.
+	outlierRuleMinMax = "min-max"
+	// outlierRuleStdDev picks everyone more than one standard deviation away
+	// from the mean.
+	outlierRuleStdDev = "stddev"
+	outlierRuleNone   = "none"
+)
+
+const maxJustificationLength = 280
+
+// Justification is the short explanation an outlier gave for their vote.
+type Justification struct {
+	Player string `json:"player"`
+	Vote   int    `json:"vote"`
+	Text   string `json:"text"`
+	// Iteration is the number of the vote the justification belongs to.
+	Iteration int       `json:"iteration"`
+	At        time.Time `json:"at"`
+}
+
+func validOutlierRule(rule string) bool {
+	switch rule {
+	case "", outlierRuleMinMax, outlierRuleStdDev, outlierRuleNone:
+		return true
+	}
+	return false
+}
+
+// findOutliers returns the players whose votes stand out according to the
+// rule, sorted by name. A unanimous vote has no outliers.
+func findOutliers(votes map[string]int, rule string) []string {
+	outliers := []string{}
+	if len(votes) < 2 || rule == outlierRuleNone {
+		return outliers
+	}
+
+	switch rule {
+	case outlierRuleStdDev:
+		mean := 0.0
+		for _, v := range votes {
+			mean += float64(v)
+		}
+		mean /= float64(len(votes))
+
+		variance := 0.0
+		for _, v := range votes {
+			variance += (float64(v) - mean) * (float64(v) - mean)
+		}
+		deviation := math.Sqrt(variance / float64(len(votes)))
+		if deviation == 0 {
+			return outliers
+		}
+
+		for player, v := range votes {
+			if math.Abs(float64(v)-mean) > deviation {
+				outliers = append(outliers, player)
+			}
+		}
+	default:
+		low, high := math.MaxInt, math.MinInt
+		for _, v := range votes {
+			low = min(low, v)
+			high = max(high, v)
+		}
+		if low == high {
+			return outliers
+		}
+
+		for player, v := range votes {
+			if v == low || v == high {
+				outliers = append(outliers, player)
+			}
+		}
+	}
+
+	sort.Strings(outliers)
+	return outliers
+}
+
+// askOutliers marks the outliers of the active story and asks them to speak.
+// Marking them is an edit of the story, so it changes the stories version.
+func askOutliers(session *Session) {
+	round := session.CurrentRound
+	if round == nil {
+		return
+	}
+	story := round.findStory(round.ActiveStory)
+	if story == nil {
+		return
+	}
+
+	story.Outliers = findOutliers(round.Votes[story.ID], session.Settings.OutlierRule)
+	round.StoriesVersion++
+	notifyPlayers(session.ID, story.Outliers, fmt.Sprintf("/speak-up:%s", story.ID))
+}
+
+func (s *Story) isOutlier(player string) bool {
+	for _, outlier := range s.Outliers {
+		if outlier == player {
+			return true
+		}
+	}
+	return false
+}
+
+func addJustificationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	var payload struct {
+		PlayerName string `json:"playerName"`
+		Text       string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Text == "" {
+		http.Error(w, "Treść uzasadnienia jest wymagana", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(payload.Text)) > maxJustificationLength {
+		http.Error(w, fmt.Sprintf("Uzasadnienie może mieć najwyżej %d znaków", maxJustificationLength), http.StatusBadRequest)
+		return
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	if !story.isOutlier(payload.PlayerName) {
+		http.Error(w, "Gracz nie został poproszony o uzasadnienie", http.StatusForbidden)
+		return
+	}
+
+	lists := session.storyLists()
+	story.Justifications = append(story.Justifications, &Justification{
+		Player:    payload.PlayerName,
+		Vote:      session.CurrentRound.Votes[storyID][payload.PlayerName],
+		Text:      payload.Text,
+		Iteration: len(story.Iterations) + 1,
+		At:        time.Now().UTC(),
+	})
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/justification-added:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
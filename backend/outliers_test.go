@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFindOutliers(t *testing.T) {
+	votes := map[string]int{"Ala": 3, "Jacek": 5, "Ola": 5, "Piotr": 13}
+
+	if got := findOutliers(votes, ""); !reflect.DeepEqual(got, []string{"Ala", "Piotr"}) {
+		t.Errorf("reguła min-max: otrzymano %v", got)
+	}
+	if got := findOutliers(votes, outlierRuleStdDev); !reflect.DeepEqual(got, []string{"Piotr"}) {
+		t.Errorf("reguła stddev: otrzymano %v", got)
+	}
+	if got := findOutliers(votes, outlierRuleNone); len(got) != 0 {
+		t.Errorf("reguła none: otrzymano %v", got)
+	}
+	if got := findOutliers(map[string]int{"Ala": 3, "Jacek": 3}, outlierRuleMinMax); len(got) != 0 {
+		t.Errorf("jednomyślne głosowanie nie ma skrajnych głosów, otrzymano %v", got)
+	}
+}
+
+func TestFindOutliersRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		votes map[string]int
+		rule  string
+		want  []string
+	}{
+		{"min-max z remisami", map[string]int{"Ala": 2, "Basia": 2, "Jacek": 5, "Ola": 8, "Piotr": 8}, outlierRuleMinMax, []string{"Ala", "Basia", "Ola", "Piotr"}},
+		{"stddev z remisami", map[string]int{"Ala": 1, "Basia": 1, "Jacek": 5, "Ola": 5, "Piotr": 5, "Zosia": 5}, outlierRuleStdDev, []string{"Ala", "Basia"}},
+		{"stddev bez odstających", map[string]int{"Ala": 3, "Basia": 3, "Jacek": 5, "Ola": 5}, outlierRuleStdDev, []string{}},
+		{"stddev jednomyślnie", map[string]int{"Ala": 5, "Jacek": 5, "Ola": 5}, outlierRuleStdDev, []string{}},
+		{"none mimo rozbieżności", map[string]int{"Ala": 1, "Jacek": 100}, outlierRuleNone, []string{}},
+		{"brak głosów", map[string]int{}, outlierRuleMinMax, []string{}},
+		{"jeden głos min-max", map[string]int{"Ala": 13}, outlierRuleMinMax, []string{}},
+		{"jeden głos stddev", map[string]int{"Ala": 13}, outlierRuleStdDev, []string{}},
+		{"dwa głosy min-max", map[string]int{"Ala": 3, "Jacek": 8}, outlierRuleMinMax, []string{"Ala", "Jacek"}},
+		// Two votes are both exactly one deviation from the mean.
+		{"dwa głosy stddev", map[string]int{"Ala": 3, "Jacek": 8}, outlierRuleStdDev, []string{}},
+	}
+
+	for _, c := range cases {
+		if got := findOutliers(c.votes, c.rule); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: oczekiwano %v, otrzymano %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestValidOutlierRule(t *testing.T) {
+	for _, rule := range []string{"", outlierRuleMinMax, outlierRuleStdDev, outlierRuleNone} {
+		if !validOutlierRule(rule) {
+			t.Errorf("reguła %q powinna być poprawna", rule)
+		}
+	}
+	if validOutlierRule("median") {
+		t.Error("nieznana reguła nie powinna być poprawna")
+	}
+}
+
+func TestAddJustificationOnlyFromOutliers(t *testing.T) {
+	requireMongo(t)
+
+	story := newStory("Logowanie", "", "")
+	story.Outliers = []string{"Piotr"}
+	session := &Session{Name: "Uzasadnienia"}
+	prepareSession(session, "")
+	session.Players = []string{"Ala", "Piotr"}
+	session.CurrentRound = &Round{
+		ID:          "round-1",
+		Stories:     []*Story{story},
+		ActiveStory: story.ID,
+		Votes:       map[string]map[string]int{story.ID: {"Ala": 5, "Piotr": 13}},
+	}
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	justify := func(player string) int {
+		body := `{"playerName": "` + player + `", "text": "Brakuje testów"}`
+		req := httptest.NewRequest("POST", "/sessions/"+session.ID+"/stories/"+story.ID+"/justifications", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": session.ID, "storyId": story.ID})
+		rr := httptest.NewRecorder()
+		addJustificationHandler(rr, req)
+		return rr.Code
+	}
+
+	if code := justify("Ala"); code != http.StatusForbidden {
+		t.Errorf("gracz spoza skrajnych głosów: oczekiwano %d, otrzymano %d", http.StatusForbidden, code)
+	}
+	if code := justify("Piotr"); code != http.StatusOK {
+		t.Errorf("skrajny głos: oczekiwano %d, otrzymano %d", http.StatusOK, code)
+	}
+
+	saved, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	justifications := saved.CurrentRound.findStory(story.ID).Justifications
+	if len(justifications) != 1 || justifications[0].Player != "Piotr" || justifications[0].Vote != 13 {
+		t.Errorf("nieprawidłowe uzasadnienia: %+v", justifications)
+	}
+}
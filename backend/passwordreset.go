@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const passwordResetTTL = time.Hour
+
+// PasswordReset is a single-use password reset request. Only the SHA-256 hash
+// of the token sent to the user is stored.
+type PasswordReset struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId" bson:"userid"`
+	TokenHash string    `json:"-" bson:"tokenhash"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresat"`
+	Used      bool      `json:"used"`
+}
+
+func frontendURL(path string) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "https://kat-poker.vercel.app"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+func newResetToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashResetToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func sendPasswordReset(username string) {
+	user, err := getUserByUsername(username)
+	if err != nil || user.Email == "" {
+		return
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		log.Printf("Błąd przy generowaniu tokenu resetu hasła: %v", err)
+		return
+	}
+
+	reset := &PasswordReset{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+
+	if err := savePasswordReset(reset); err != nil {
+		log.Printf("Błąd przy zapisie resetu hasła: %v", err)
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Cześć %s,\n\nAby ustawić nowe hasło, otwórz poniższy link:\n%s\n\nLink jest ważny przez godzinę i można go użyć tylko raz. Jeśli to nie Ty prosiłeś o zmianę hasła, zignoruj tę wiadomość.\n",
+		user.Username,
+		frontendURL("/reset-password?token="+token),
+	)
+
+	if err := mailer.Send(user.Email, "Reset hasła w Kat Poker", body); err != nil {
+		log.Printf("Błąd przy wysyłaniu linku resetu hasła: %v", err)
+	}
+}
+
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Username == "" {
+		http.Error(w, "Nazwa użytkownika jest wymagana", http.StatusBadRequest)
+		return
+	}
+
+	// The lookup and the e-mail happen in the background, so neither the
+	// response nor its timing reveals whether the account exists.
+	go sendPasswordReset(payload.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{
+		Message: "Jeśli konto istnieje, wysłaliśmy link do zmiany hasła",
+	})
+}
+
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Nieprawidłowe dane wejściowe", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Token == "" || payload.Password == "" {
+		http.Error(w, "Token i nowe hasło są wymagane", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := hashResetToken(payload.Token)
+	reset, err := findPasswordReset(tokenHash)
+	if err != nil {
+		http.Error(w, "Link do zmiany hasła jest nieprawidłowy lub wygasł", http.StatusBadRequest)
+		return
+	}
+
+	user, err := getUserByID(reset.UserID)
+	if err != nil {
+		http.Error(w, "Użytkownik nie znaleziony", http.StatusNotFound)
+		return
+	}
+
+	// A rejected password leaves the link valid, so the user can try again.
+	if violations := policy.checkPassword(payload.Password, user.Username); len(violations) > 0 {
+		writePolicyViolations(w, violations)
+		return
+	}
+
+	if _, err := consumePasswordReset(tokenHash); err != nil {
+		http.Error(w, "Link do zmiany hasła jest nieprawidłowy lub wygasł", http.StatusBadRequest)
+		return
+	}
+
+	// Clearing the token logs the user out of every device.
+	user.Password = hashPassword(payload.Password)
+	user.Token = ""
+	user.TokenTime = 0
+
+	if err := updateUserPassword(user); err != nil {
+		http.Error(w, "Błąd podczas zmiany hasła", http.StatusInternalServerError)
+		log.Printf("Błąd przy zmianie hasła: %v", err)
+		return
+	}
+
+	log.Printf("Użytkownik %s ustawił nowe hasło przez link resetu.", user.Username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestResetTokenIsRandomAndHashed(t *testing.T) {
+	first, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Error("oczekiwano różnych tokenów")
+	}
+	if hashResetToken(first) == first {
+		t.Error("hash tokenu nie może być równy tokenowi")
+	}
+	if hashResetToken(first) != hashResetToken(first) {
+		t.Error("hash tokenu powinien być deterministyczny")
+	}
+}
+
+func TestFileMailerWritesMessage(t *testing.T) {
+	dir := t.TempDir()
+	m := &fileMailer{dir: dir}
+
+	if err := m.Send("ala@example.com", "Reset hasła", "link: https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("oczekiwano 1 wiadomości, otrzymano %d", len(files))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(data)
+	if !strings.Contains(message, "To: ala@example.com") || !strings.Contains(message, "link: https://example.com") {
+		t.Errorf("nieoczekiwana wiadomość: %s", message)
+	}
+	if !strings.Contains(message, "Subject: =?utf-8?q?") {
+		t.Errorf("temat nie jest zakodowany: %s", message)
+	}
+}
+
+func TestResetPasswordKeepsTokenAfterRejectedPassword(t *testing.T) {
+	requireMongo(t)
+	router := setupRouter()
+
+	user := &User{ID: uuid.New().String(), Username: "reset-" + uuid.New().String()[:8], Password: "Stare-haslo-123"}
+	if err := saveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	token, err := newResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := &PasswordReset{ID: uuid.New().String(), UserID: user.ID, TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := savePasswordReset(reset); err != nil {
+		t.Fatal(err)
+	}
+
+	resetPassword := func(password string) int {
+		body, _ := json.Marshal(map[string]string{"token": token, "password": password})
+		req := httptest.NewRequest("POST", "/password/reset", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := resetPassword("abc"); code != http.StatusBadRequest {
+		t.Fatalf("oczekiwano odrzucenia słabego hasła, otrzymano %d", code)
+	}
+	if code := resetPassword("Nowe-haslo-456"); code != http.StatusNoContent {
+		t.Fatalf("link powinien działać po odrzuconym haśle, otrzymano %d", code)
+	}
+	if code := resetPassword("Inne-haslo-789"); code != http.StatusBadRequest {
+		t.Errorf("link powinien być jednorazowy, otrzymano %d", code)
+	}
+}
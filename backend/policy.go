@@ -0,0 +1,203 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsList string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsList)
+
+// credentialPolicy describes the rules usernames and passwords have to follow.
+// The defaults can be changed with the environment variables read in
+// loadCredentialPolicy.
+type credentialPolicy struct {
+	MinPasswordLength     int
+	MaxPasswordLength     int
+	MinUsernameLength     int
+	MaxUsernameLength     int
+	RejectCommonPasswords bool
+}
+
+var policy = loadCredentialPolicy()
+
+// policyViolation is a single broken rule, reported to the client so that the
+// form can show every problem at once.
+type policyViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func loadCredentialPolicy() credentialPolicy {
+	p := credentialPolicy{
+		MinPasswordLength:     8,
+		MaxPasswordLength:     128,
+		MinUsernameLength:     3,
+		MaxUsernameLength:     32,
+		RejectCommonPasswords: true,
+	}
+
+	p.MinPasswordLength = envInt("PASSWORD_MIN_LENGTH", p.MinPasswordLength)
+	p.MaxPasswordLength = envInt("PASSWORD_MAX_LENGTH", p.MaxPasswordLength)
+	p.MinUsernameLength = envInt("USERNAME_MIN_LENGTH", p.MinUsernameLength)
+	p.MaxUsernameLength = envInt("USERNAME_MAX_LENGTH", p.MaxUsernameLength)
+	if os.Getenv("PASSWORD_REJECT_COMMON") == "false" {
+		p.RejectCommonPasswords = false
+	}
+
+	return p
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Nieprawidłowa wartość %s=%q, używam %d", name, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func parseCommonPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}
+
+// normalizeUsername returns the NFKC form of the username without surrounding
+// whitespace. This is the form that is stored and displayed.
+func normalizeUsername(username string) string {
+	return strings.TrimSpace(norm.NFKC.String(username))
+}
+
+// usernameKey is the case-folded form of the username used to check for
+// uniqueness, so "Ala", "ALA" and "Ａｌａ" belong to the same account.
+func usernameKey(username string) string {
+	return norm.NFKC.String(cases.Fold().String(normalizeUsername(username)))
+}
+
+func (p credentialPolicy) checkUsername(username string) []policyViolation {
+	var violations []policyViolation
+	add := func(rule, message string) {
+		violations = append(violations, policyViolation{Field: "username", Rule: rule, Message: message})
+	}
+
+	username = normalizeUsername(username)
+	if username == "" {
+		add("required", "Nazwa użytkownika jest wymagana")
+		return violations
+	}
+
+	length := len([]rune(username))
+	if length < p.MinUsernameLength {
+		add("min-length", fmt.Sprintf("Minimalna długość nazwy użytkownika to %d", p.MinUsernameLength))
+	}
+	if p.MaxUsernameLength > 0 && length > p.MaxUsernameLength {
+		add("max-length", fmt.Sprintf("Maksymalna długość nazwy użytkownika to %d", p.MaxUsernameLength))
+	}
+
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) && !strings.ContainsRune("._- ", r) {
+			add("characters", "Nazwa użytkownika może zawierać tylko litery, cyfry, spacje oraz znaki . _ -")
+			break
+		}
+	}
+
+	if strings.Contains(username, "  ") {
+		add("whitespace", "Nazwa użytkownika nie może zawierać kilku spacji z rzędu")
+	}
+
+	if len(usernameScripts(username)) > 1 {
+		add("mixed-scripts", "Nazwa użytkownika nie może łączyć liter z różnych alfabetów")
+	}
+
+	return violations
+}
+
+// usernameScripts lists the alphabets used by the letters of the username.
+// Mixing them is the usual way of building look-alike names, e.g. a Cyrillic
+// "а" in place of a Latin "a".
+func usernameScripts(username string) map[string]bool {
+	scripts := map[string]*unicode.RangeTable{
+		"Latin":    unicode.Latin,
+		"Cyrillic": unicode.Cyrillic,
+		"Greek":    unicode.Greek,
+		"Armenian": unicode.Armenian,
+	}
+
+	used := make(map[string]bool)
+	for _, r := range username {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for name, table := range scripts {
+			if unicode.Is(table, r) {
+				used[name] = true
+			}
+		}
+	}
+	return used
+}
+
+func (p credentialPolicy) checkPassword(password, username string) []policyViolation {
+	var violations []policyViolation
+	add := func(rule, message string) {
+		violations = append(violations, policyViolation{Field: "password", Rule: rule, Message: message})
+	}
+
+	if password == "" {
+		add("required", "Hasło jest wymagane")
+		return violations
+	}
+
+	length := len([]rune(password))
+	if length < p.MinPasswordLength {
+		add("min-length", fmt.Sprintf("Minimalna długość hasła to %d", p.MinPasswordLength))
+	}
+	if p.MaxPasswordLength > 0 && length > p.MaxPasswordLength {
+		add("max-length", fmt.Sprintf("Maksymalna długość hasła to %d", p.MaxPasswordLength))
+	}
+
+	if p.RejectCommonPasswords && commonPasswords[strings.ToLower(password)] {
+		add("common", "Hasło znajduje się na liście popularnych lub wykradzionych haseł")
+	}
+
+	if username != "" && strings.Contains(usernameKey(password), usernameKey(username)) {
+		add("contains-username", "Hasło nie może zawierać nazwy użytkownika")
+	}
+
+	return violations
+}
+
+func writePolicyViolations(w http.ResponseWriter, violations []policyViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error      string            `json:"error"`
+		Violations []policyViolation `json:"violations"`
+	}{
+		Error:      "Dane nie spełniają wymagań",
+		Violations: violations,
+	})
+}
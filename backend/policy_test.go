@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func violationRules(violations []policyViolation) map[string]bool {
+	rules := make(map[string]bool)
+	for _, v := range violations {
+		rules[v.Field+":"+v.Rule] = true
+	}
+	return rules
+}
+
+func TestUsernameKey(t *testing.T) {
+	cases := map[string]string{
+		"Ala":     "ala",
+		"  ALA  ": "ala",
+		"Ａｌａ":     "ala",
+		"Łukasz":  "łukasz",
+	}
+	for input, want := range cases {
+		if got := usernameKey(input); got != want {
+			t.Errorf("usernameKey(%q) = %q, oczekiwano %q", input, got, want)
+		}
+	}
+}
+
+func TestCheckUsername(t *testing.T) {
+	p := credentialPolicy{MinUsernameLength: 3, MaxUsernameLength: 10}
+
+	if v := p.checkUsername("Zażółć"); len(v) != 0 {
+		t.Errorf("nie oczekiwano błędów, otrzymano %v", v)
+	}
+
+	rules := violationRules(p.checkUsername("   "))
+	if !rules["username:required"] {
+		t.Errorf("oczekiwano required, otrzymano %v", rules)
+	}
+
+	rules = violationRules(p.checkUsername("a!"))
+	if !rules["username:min-length"] || !rules["username:characters"] {
+		t.Errorf("oczekiwano min-length i characters, otrzymano %v", rules)
+	}
+
+	// Cyrillic "а" mixed with Latin letters.
+	rules = violationRules(p.checkUsername("jаcek"))
+	if !rules["username:mixed-scripts"] {
+		t.Errorf("oczekiwano mixed-scripts, otrzymano %v", rules)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	p := credentialPolicy{MinPasswordLength: 8, MaxPasswordLength: 64, RejectCommonPasswords: true}
+
+	if v := p.checkPassword("korek-w-tramwaju", "ala"); len(v) != 0 {
+		t.Errorf("nie oczekiwano błędów, otrzymano %v", v)
+	}
+
+	rules := violationRules(p.checkPassword("x", "ala"))
+	if !rules["password:min-length"] {
+		t.Errorf("oczekiwano min-length, otrzymano %v", rules)
+	}
+
+	rules = violationRules(p.checkPassword("Password1", "ala"))
+	if !rules["password:common"] {
+		t.Errorf("oczekiwano common, otrzymano %v", rules)
+	}
+
+	rules = violationRules(p.checkPassword("tajne-ALA-haslo", "ala"))
+	if !rules["password:contains-username"] {
+		t.Errorf("oczekiwano contains-username, otrzymano %v", rules)
+	}
+
+	p.RejectCommonPasswords = false
+	if v := p.checkPassword("password1", "ala"); len(v) != 0 {
+		t.Errorf("nie oczekiwano błędów przy wyłączonej liście, otrzymano %v", v)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed templates/report.md.tmpl templates/report.html.tmpl
+var reportTemplates embed.FS
+
+// sessionReport is the data passed to the report templates.
+type sessionReport struct {
+	ID              string
+	Name            string
+	GeneratedAt     time.Time
+	StartedAt       time.Time
+	EndedAt         time.Time
+	Duration        time.Duration
+	Participants    []reportParticipant
+	Rounds          []reportRound
+	CommittedPoints int
+}
+
+type reportParticipant struct {
+	Name   string
+	Avatar string
+}
+
+type reportRound struct {
+	ID              string
+	StartedAt       time.Time
+	Anonymous       bool
+	Stories         []reportStory
+	CommittedPoints int
+}
+
+type reportStory struct {
+	Title          string
+	Description    string
+	Votes          []reportVote
+	Consensus      bool
+	ConsensusValue int
+	FinalEstimate  *int
+	EstimateNote   string
+	Iterations     int
+}
+
+type reportVote struct {
+	Player string
+	Value  int
+}
+
+// buildSessionReport gathers the report data. Avatars maps player names to the
+// avatars of their accounts; guests have none.
+func buildSessionReport(session *Session, avatars map[string]string) *sessionReport {
+	report := &sessionReport{
+		ID:          session.ID,
+		Name:        session.Name,
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	for _, player := range session.Players {
+		report.Participants = append(report.Participants, reportParticipant{
+			Name:   player,
+			Avatar: avatars[player],
+		})
+	}
+
+	rounds := append([]*Round{}, session.RoundHistory...)
+	if session.CurrentRound != nil {
+		rounds = append(rounds, session.CurrentRound)
+	}
+
+	for _, round := range rounds {
+		entry := reportRound{
+			ID:              round.ID,
+			StartedAt:       round.StartedAt,
+			Anonymous:       round.Anonymous,
+			CommittedPoints: round.committedPoints(),
+		}
+
+		for _, story := range round.Stories {
+			votes := round.Votes[story.ID]
+			value, consensus := consensusValue(votes)
+			entry.Stories = append(entry.Stories, reportStory{
+				Title:          story.Title,
+				Description:    story.Description,
+				Votes:          sortedVotes(votes),
+				Consensus:      consensus,
+				ConsensusValue: value,
+				FinalEstimate:  story.FinalEstimate,
+				EstimateNote:   story.EstimateNote,
+				Iterations:     len(story.Iterations),
+			})
+		}
+
+		report.Rounds = append(report.Rounds, entry)
+		report.CommittedPoints += entry.CommittedPoints
+
+		if !round.StartedAt.IsZero() && (report.StartedAt.IsZero() || round.StartedAt.Before(report.StartedAt)) {
+			report.StartedAt = round.StartedAt
+		}
+		if round.StartedAt.After(report.EndedAt) {
+			report.EndedAt = round.StartedAt
+		}
+		for _, event := range round.VoteEvents {
+			if event.At.After(report.EndedAt) {
+				report.EndedAt = event.At
+			}
+		}
+	}
+
+	if !report.StartedAt.IsZero() {
+		report.Duration = report.EndedAt.Sub(report.StartedAt)
+	}
+
+	return report
+}
+
+func sortedVotes(votes map[string]int) []reportVote {
+	sorted := make([]reportVote, 0, len(votes))
+	for player, value := range votes {
+		sorted = append(sorted, reportVote{Player: player, Value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Player < sorted[j].Player })
+	return sorted
+}
+
+var reportFuncs = map[string]any{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "—"
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"duration": func(d time.Duration) string {
+		if d <= 0 {
+			return "—"
+		}
+		return fmt.Sprintf("%d h %02d min", int(d.Hours()), int(d.Minutes())%60)
+	},
+	"estimate": func(value *int) string {
+		if value == nil {
+			return "—"
+		}
+		return fmt.Sprint(*value)
+	},
+	// cell keeps user text from breaking Markdown tables.
+	"cell": func(s string) string {
+		s = strings.ReplaceAll(s, "|", `\|`)
+		return strings.Join(strings.Fields(s), " ")
+	},
+}
+
+// loadReportTemplate reads the template from REPORT_TEMPLATE_DIR when the
+// file exists there and falls back to the embedded one.
+func loadReportTemplate(name string) ([]byte, error) {
+	if dir := os.Getenv("REPORT_TEMPLATE_DIR"); dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return reportTemplates.ReadFile("templates/" + name)
+}
+
+func renderSessionReport(report *sessionReport, format string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if format == "html" {
+		source, err := loadReportTemplate("report.html.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := htmltemplate.New("report").Funcs(reportFuncs).Parse(string(source))
+		if err != nil {
+			return nil, err
+		}
+		if err := tmpl.Execute(&buf, report); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	source, err := loadReportTemplate("report.md.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := texttemplate.New("report").Funcs(reportFuncs).Parse(string(source))
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(&buf, report); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// memberAvatars maps the player names of the session members to their
+// avatars. Guests are not members, so a guest who typed the name of a
+// registered user does not get their avatar.
+func memberAvatars(session *Session, members []*User) map[string]string {
+	avatars := make(map[string]string)
+	for _, user := range members {
+		for _, player := range session.Players {
+			if usernameKey(player) == usernameKey(user.Username) {
+				avatars[player] = user.Avatar
+			}
+		}
+	}
+	return avatars
+}
+
+func sessionReportHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+	if format != "md" && format != "html" {
+		http.Error(w, "Nieobsługiwany format raportu", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	members, err := getUsersByIDs(session.Members)
+	if err != nil {
+		http.Error(w, "Błąd przy pobieraniu uczestników", http.StatusInternalServerError)
+		return
+	}
+
+	session.hideVoters()
+	data, err := renderSessionReport(buildSessionReport(session, memberAvatars(session, members)), format)
+	if err != nil {
+		http.Error(w, "Błąd przy tworzeniu raportu", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	_, _ = w.Write(data)
+}
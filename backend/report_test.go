@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reportSession() *Session {
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	five := 5
+	return &Session{
+		ID:      "session-1",
+		Name:    "Sprint 7",
+		Players: []string{"Ala", "Jacek"},
+		CurrentRound: &Round{
+			ID:        "round-1",
+			StartedAt: start,
+			Votes:     map[string]map[string]int{"a": {"Ala": 5, "Jacek": 5}, "b": {"Ala": 3, "Jacek": 8}},
+			Stories: []*Story{
+				{ID: "a", Title: "Logowanie | SSO", FinalEstimate: &five},
+				{ID: "b", Title: "<script>alert(1)</script>"},
+			},
+			VoteEvents: []*VoteEvent{{Type: voteEventVoted, At: start.Add(90 * time.Minute)}},
+		},
+	}
+}
+
+func TestMemberAvatars(t *testing.T) {
+	session := reportSession()
+	session.Members = []string{"u1"}
+	members := []*User{{ID: "u1", Username: "ala", Avatar: "🦄"}}
+
+	avatars := memberAvatars(session, members)
+	if avatars["Ala"] != "🦄" {
+		t.Errorf("członek sesji powinien mieć awatar: %v", avatars)
+	}
+	if _, ok := avatars["Jacek"]; ok {
+		t.Errorf("gość nie powinien mieć awatara: %v", avatars)
+	}
+
+	if avatars := memberAvatars(session, nil); len(avatars) != 0 {
+		t.Errorf("sesja bez członków nie powinna mieć awatarów: %v", avatars)
+	}
+}
+
+func TestBuildSessionReport(t *testing.T) {
+	report := buildSessionReport(reportSession(), map[string]string{"Ala": "🦄"})
+
+	if report.Duration != 90*time.Minute {
+		t.Errorf("oczekiwano 90 minut, otrzymano %v", report.Duration)
+	}
+	if report.Participants[0].Avatar != "🦄" || report.Participants[1].Avatar != "" {
+		t.Errorf("nieoczekiwani uczestnicy: %+v", report.Participants)
+	}
+	stories := report.Rounds[0].Stories
+	if !stories[0].Consensus || stories[1].Consensus {
+		t.Errorf("nieoczekiwane flagi konsensusu: %+v", stories)
+	}
+	if report.CommittedPoints != 5 {
+		t.Errorf("oczekiwano 5 punktów, otrzymano %d", report.CommittedPoints)
+	}
+}
+
+func TestRenderSessionReport(t *testing.T) {
+	report := buildSessionReport(reportSession(), nil)
+
+	md, err := renderSessionReport(report, "md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(md), `Logowanie \| SSO`) || !strings.Contains(string(md), "1 h 30 min") {
+		t.Errorf("nieoczekiwany raport Markdown:\n%s", md)
+	}
+
+	html, err := renderSessionReport(report, "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(html), "<script>") {
+		t.Error("raport HTML nie escapuje treści user stories")
+	}
+}
+
+func TestReportTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "report.md.tmpl"), []byte("Raport {{.Name}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REPORT_TEMPLATE_DIR", dir)
+
+	md, err := renderSessionReport(buildSessionReport(reportSession(), nil), "md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(md) != "Raport Sprint 7" {
+		t.Errorf("oczekiwano własnego szablonu, otrzymano %q", md)
+	}
+
+	if _, err := renderSessionReport(buildSessionReport(reportSession(), nil), "html"); err != nil {
+		t.Errorf("brak własnego szablonu HTML powinien użyć wbudowanego: %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// slackMaxClockSkew rejects replayed requests, as Slack recommends.
+	slackMaxClockSkew   = 5 * time.Minute
+	slackMaxBodySize    = 64 << 10
+	chatLinkCodeTTL     = 10 * time.Minute
+	slackInChannel      = "in_channel"
+	slackEphemeral      = "ephemeral"
+	chatProviderSlack   = "slack"
+	slackCommandHelpMsg = "Dostępne polecenia:\n" +
+		"• `/poker new \"Nazwa sesji\"` – nowa sesja z linkiem do dołączenia\n" +
+		"• `/poker start <id sesji>` – rozpoczęcie kolejnej rundy\n" +
+		"• `/poker results <id sesji>` – podsumowanie bieżącej rundy\n" +
+		"• `/poker link <kod>` – połączenie z kontem Kat Poker (kod z ustawień konta)"
+)
+
+// ChatLink connects a chat account with a Kat Poker user, so that sessions
+// created from chat have a facilitator.
+type ChatLink struct {
+	// ID is "<provider>:<chat team>:<chat user>".
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// chatLinkCode is a one-time code a logged in user types in chat to link the
+// chat account.
+type chatLinkCode struct {
+	CodeHash  string    `bson:"codehash"`
+	UserID    string    `bson:"userid"`
+	ExpiresAt time.Time `bson:"expiresat"`
+}
+
+func chatLinkID(provider, teamID, chatUserID string) string {
+	return provider + ":" + teamID + ":" + chatUserID
+}
+
+// slashCommand is the form payload of a Slack slash command.
+type slashCommand struct {
+	Command  string
+	Text     string
+	TeamID   string
+	UserID   string
+	UserName string
+}
+
+type slackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+func ephemeral(format string, args ...any) slackResponse {
+	return slackResponse{ResponseType: slackEphemeral, Text: fmt.Sprintf(format, args...)}
+}
+
+func inChannel(format string, args ...any) slackResponse {
+	return slackResponse{ResponseType: slackInChannel, Text: fmt.Sprintf(format, args...)}
+}
+
+// verifySlackRequest checks the v0 signature of the request body as described
+// in the Slack documentation.
+func verifySlackRequest(secret, timestamp, signature string, body []byte, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > slackMaxClockSkew || skew < -slackMaxClockSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// splitCommandArgs splits the text on spaces, keeping quoted parts together.
+// Chat clients often replace straight quotes with typographic ones.
+func splitCommandArgs(text string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, started := false, false
+
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”' || r == '„':
+			quoted = !quoted
+			started = true
+		case r == ' ' && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+func parseSlashCommand(body []byte) (*slashCommand, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if form.Get("user_id") == "" {
+		return nil, errors.New("brak użytkownika w poleceniu")
+	}
+	return &slashCommand{
+		Command:  form.Get("command"),
+		Text:     strings.TrimSpace(form.Get("text")),
+		TeamID:   form.Get("team_id"),
+		UserID:   form.Get("user_id"),
+		UserName: form.Get("user_name"),
+	}, nil
+}
+
+// runSlashCommand maps the subcommand to the same operations as the HTTP API.
+func runSlashCommand(cmd *slashCommand) slackResponse {
+	args := splitCommandArgs(cmd.Text)
+	if len(args) == 0 {
+		return ephemeral(slackCommandHelpMsg)
+	}
+
+	linkID := chatLinkID(chatProviderSlack, cmd.TeamID, cmd.UserID)
+	switch strings.ToLower(args[0]) {
+	case "new":
+		return slashNewSession(linkID, strings.Join(args[1:], " "))
+	case "start":
+		if len(args) != 2 {
+			return ephemeral("Użycie: `/poker start <id sesji>`")
+		}
+		return slashStartRound(linkID, args[1])
+	case "results":
+		if len(args) != 2 {
+			return ephemeral("Użycie: `/poker results <id sesji>`")
+		}
+		return slashResults(args[1])
+	case "link":
+		if len(args) != 2 {
+			return ephemeral("Użycie: `/poker link <kod>`. Kod wygenerujesz w ustawieniach konta Kat Poker.")
+		}
+		return slashLink(linkID, args[1])
+	case "help":
+		return ephemeral(slackCommandHelpMsg)
+	}
+	return ephemeral("Nieznane polecenie %q.\n%s", args[0], slackCommandHelpMsg)
+}
+
+// linkedUserID returns the Kat Poker user linked with the chat account, or an
+// empty string.
+func linkedUserID(linkID string) string {
+	link, err := getChatLink(linkID)
+	if err != nil {
+		return ""
+	}
+	return link.UserID
+}
+
+func slashNewSession(linkID, name string) slackResponse {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ephemeral("Użycie: `/poker new \"Nazwa sesji\"`")
+	}
+
+	session := &Session{Name: name}
+	prepareSession(session, linkedUserID(linkID))
+	if err := saveSession(session); err != nil {
+		log.Printf("Błąd przy tworzeniu sesji z czatu: %v", err)
+		return ephemeral("Nie udało się utworzyć sesji, spróbuj ponownie.")
+	}
+
+	return inChannel("Nowa sesja planowania *%s* (`%s`). Dołącz: %s", slackEscape(session.Name), session.ID, frontendURL("/game/"+session.ID))
+}
+
+func slashStartRound(linkID, sessionID string) slackResponse {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return ephemeral("Sesja %s nie istnieje.", slackEscape(sessionID))
+	}
+
+	if !session.isOpen() {
+		return ephemeral("Sesja *%s* jest zamknięta.", slackEscape(session.Name))
+	}
+
+	if session.FacilitatorID != "" && session.FacilitatorID != linkedUserID(linkID) {
+		return ephemeral("Tylko prowadzący sesję może rozpocząć rundę. Połącz konto poleceniem `/poker link <kod>`.")
+	}
+
+	lists := session.storyLists()
+	round := startNextRound(session)
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
+		log.Printf("Błąd przy rozpoczynaniu rundy z czatu: %v", err)
+	}
+	if err != nil || !saved {
+		return ephemeral("Nie udało się rozpocząć rundy, spróbuj ponownie.")
+	}
+	notifySessionParticipants(session.ID, "/starting")
+	publishRoundStarted(session, round)
+
+	return inChannel("Runda %s w sesji *%s* rozpoczęta (%d user stories). Dołącz: %s",
+		round.ID, slackEscape(session.Name), len(round.Stories), frontendURL("/game/"+session.ID))
+}
+
+// slashResults summarizes the current round. Only statistics are shown, so
+// the summary is safe for anonymous sessions too.
+func slashResults(sessionID string) slackResponse {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return ephemeral("Sesja %s nie istnieje.", slackEscape(sessionID))
+	}
+	if session.CurrentRound == nil {
+		return ephemeral("W sesji *%s* nie rozpoczęto jeszcze rundy.", slackEscape(session.Name))
+	}
+	return inChannel("%s", formatRoundSummary(session))
+}
+
+func formatRoundSummary(session *Session) string {
+	round := session.CurrentRound
+	var b strings.Builder
+	fmt.Fprintf(&b, "Wyniki rundy %s w sesji *%s*:", round.ID, slackEscape(session.Name))
+	if len(round.Stories) == 0 {
+		b.WriteString("\nBrak user stories.")
+	}
+	for _, story := range round.Stories {
+		fmt.Fprintf(&b, "\n• %s: ", slackEscape(story.Title))
+		stats := computeVoteStatistics(round.Votes[story.ID])
+		switch {
+		case story.FinalEstimate != nil:
+			fmt.Fprintf(&b, "%d SP", *story.FinalEstimate)
+		case stats.Count > 0:
+			fmt.Fprintf(&b, "%d głosów, %d–%d, mediana %s", stats.Count, *stats.Min, *stats.Max, formatOptionalFloat(stats.Median))
+		default:
+			b.WriteString("brak głosów")
+		}
+	}
+	return b.String()
+}
+
+func slashLink(linkID, code string) slackResponse {
+	userID, err := useChatLinkCode(strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return ephemeral("Kod jest nieprawidłowy lub wygasł. Wygeneruj nowy w ustawieniach konta.")
+	}
+
+	link := &ChatLink{ID: linkID, UserID: userID, CreatedAt: time.Now().UTC()}
+	if err := saveChatLink(link); err != nil {
+		log.Printf("Błąd przy łączeniu konta czatu: %v", err)
+		return ephemeral("Nie udało się połączyć konta, spróbuj ponownie.")
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		return ephemeral("Konto zostało połączone.")
+	}
+	return ephemeral("Połączono z kontem Kat Poker *%s*.", slackEscape(user.Username))
+}
+
+// slackCommandHandler implements the Slack slash command protocol. Errors of
+// the command itself are answered with 200 and an ephemeral message, because
+// Slack shows other responses only as a generic failure.
+func slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+	if secret == "" {
+		http.Error(w, "Integracja ze Slackiem jest wyłączona", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, slackMaxBodySize))
+	if err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	if !verifySlackRequest(secret, timestamp, r.Header.Get("X-Slack-Signature"), body, time.Now()) {
+		http.Error(w, "Nieprawidłowy podpis", http.StatusUnauthorized)
+		return
+	}
+
+	cmd, err := parseSlashCommand(body)
+	if err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(runSlashCommand(cmd)); err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// createChatLinkCodeHandler gives the logged in user a code to type in chat
+// with `/poker link <code>`.
+func createChatLinkCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+	code := strings.ToUpper(token[:10])
+
+	linkCode := &chatLinkCode{
+		CodeHash:  hashResetToken(code),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(chatLinkCodeTTL),
+	}
+	if err := saveChatLinkCode(linkCode); err != nil {
+		http.Error(w, "Błąd przy zapisie kodu", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Code      string    `json:"code"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{code, linkCode.ExpiresAt})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func slackSignature(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySlackRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte("command=%2Fpoker&text=help&user_id=U1")
+	signature := slackSignature("sekret", timestamp, string(body))
+
+	if !verifySlackRequest("sekret", timestamp, signature, body, now) {
+		t.Errorf("Poprawny podpis odrzucony")
+	}
+	if verifySlackRequest("inny", timestamp, signature, body, now) {
+		t.Errorf("Podpis z innym sekretem zaakceptowany")
+	}
+	if verifySlackRequest("sekret", timestamp, signature, []byte("text=new"), now) {
+		t.Errorf("Podpis zmienionej treści zaakceptowany")
+	}
+	if verifySlackRequest("sekret", timestamp, signature, body, now.Add(10*time.Minute)) {
+		t.Errorf("Stare żądanie zaakceptowane")
+	}
+	if verifySlackRequest("sekret", "", signature, body, now) {
+		t.Errorf("Żądanie bez znacznika czasu zaakceptowane")
+	}
+}
+
+func TestSplitCommandArgs(t *testing.T) {
+	cases := map[string][]string{
+		`new "Sprint 42 planning"`: {"new", "Sprint 42 planning"},
+		`new “Sprint 42”`:          {"new", "Sprint 42"},
+		`  start   session-1 `:     {"start", "session-1"},
+		`new ""`:                   {"new", ""},
+		``:                         nil,
+	}
+	for text, want := range cases {
+		got := splitCommandArgs(text)
+		if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("%q: oczekiwano %q, otrzymano %q", text, want, got)
+		}
+	}
+}
+
+func TestParseSlashCommand(t *testing.T) {
+	cmd, err := parseSlashCommand([]byte("command=%2Fpoker&text=new+%22Sprint+42%22&team_id=T1&user_id=U1&user_name=ala"))
+	if err != nil {
+		t.Fatalf("Błąd parsowania: %v", err)
+	}
+	if cmd.Command != "/poker" || cmd.Text != `new "Sprint 42"` || cmd.TeamID != "T1" || cmd.UserID != "U1" {
+		t.Errorf("Nieprawidłowe polecenie: %+v", cmd)
+	}
+
+	if _, err := parseSlashCommand([]byte("command=%2Fpoker&text=help")); err == nil {
+		t.Errorf("Oczekiwano błędu dla polecenia bez użytkownika")
+	}
+}
+
+func TestRunSlashCommandUsage(t *testing.T) {
+	cases := map[string]string{
+		"":             "Dostępne polecenia",
+		"help":         "Dostępne polecenia",
+		"dance":        "Nieznane polecenie",
+		"new":          "Użycie: `/poker new",
+		"start":        "Użycie: `/poker start",
+		"results a b":  "Użycie: `/poker results",
+		"link":         "Użycie: `/poker link",
+		`new "  "`:     "Użycie: `/poker new",
+		"HELP  ignore": "Dostępne polecenia",
+	}
+	for text, want := range cases {
+		response := runSlashCommand(&slashCommand{Text: text, TeamID: "T1", UserID: "U1"})
+		if response.ResponseType != slackEphemeral || !strings.Contains(response.Text, want) {
+			t.Errorf("%q: oczekiwano prywatnej odpowiedzi z %q, otrzymano %+v", text, want, response)
+		}
+	}
+}
+
+func TestFormatRoundSummary(t *testing.T) {
+	five := 5
+	session := &Session{
+		Name: "Sprint 42",
+		CurrentRound: &Round{
+			ID: "round-2",
+			Stories: []*Story{
+				{ID: "s1", Title: "Logowanie", FinalEstimate: &five},
+				{ID: "s2", Title: "Rejestracja"},
+				{ID: "s3", Title: "Eksport"},
+			},
+			Votes: map[string]map[string]int{"s2": {"Ala": 3, "Jacek": 8}},
+		},
+	}
+
+	summary := formatRoundSummary(session)
+	for _, want := range []string{"round-2", "Logowanie: 5 SP", "Rejestracja: 2 głosów, 3–8", "Eksport: brak głosów"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Podsumowanie nie zawiera %q: %s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "Ala") {
+		t.Errorf("Podsumowanie ujawnia głosujących: %s", summary)
+	}
+}
+
+func TestFormatRoundSummaryEscapesUserText(t *testing.T) {
+	session := &Session{
+		Name: "<!channel>",
+		CurrentRound: &Round{
+			ID:      "round-1",
+			Stories: []*Story{{ID: "s1", Title: "<https://evil|Dołącz> & więcej"}},
+			Votes:   map[string]map[string]int{},
+		},
+	}
+
+	summary := formatRoundSummary(session)
+	for _, want := range []string{"*&lt;!channel&gt;*", "• &lt;https://evil|Dołącz&gt; &amp; więcej: "} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Podsumowanie nie zawiera %q: %s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "<") {
+		t.Errorf("Podsumowanie zawiera nieescapowany tekst: %s", summary)
+	}
+}
+
+func TestSlackCommandHandlerVerifiesSignature(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "sekret")
+	body := "command=%2Fpoker&text=help&team_id=T1&user_id=U1"
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	send := func(signature string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/integrations/slack/commands", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		r.Header.Set("X-Slack-Signature", signature)
+		w := httptest.NewRecorder()
+		slackCommandHandler(w, r)
+		return w
+	}
+
+	if w := send("v0=00"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Oczekiwano 401 dla złego podpisu, otrzymano %d", w.Code)
+	}
+
+	w := send(slackSignature("sekret", timestamp, body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Oczekiwano 200, otrzymano %d: %s", w.Code, w.Body.String())
+	}
+	var response slackResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.ResponseType != slackEphemeral {
+		t.Errorf("Nieprawidłowa odpowiedź: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// storyLists identifies the state of the story lists of a loaded session: the
+// stories of the current round and the backlog.
+type storyLists struct {
+	roundID        string
+	roundVersion   int
+	backlogVersion int
+}
+
+func (s *Session) storyLists() storyLists {
+	lists := storyLists{backlogVersion: s.BacklogVersion}
+	if s.CurrentRound != nil {
+		lists.roundID = s.CurrentRound.ID
+		lists.roundVersion = s.CurrentRound.StoriesVersion
+	}
+	return lists
+}
+
+// condition matches the session only while neither list has changed.
+func (l storyLists) condition() bson.M {
+	condition := bson.M{"backlogversion": versionFilter(l.backlogVersion)}
+	if l.roundID == "" {
+		condition["currentround"] = nil
+	} else {
+		condition["currentround.id"] = l.roundID
+		condition["currentround.storiesversion"] = versionFilter(l.roundVersion)
+	}
+	return condition
+}
+
+// versionFilter matches the version. Documents saved before the version was
+// introduced have none, which counts as 0.
+func versionFilter(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// saveStoryLists saves a change of the story lists of the session loaded with
+// lists. The lists are written as a whole, so the save reports false when
+// either was changed in the meantime instead of overwriting that change. The
+// caller bumps the version of the list it changed.
+func saveStoryLists(session *Session, lists storyLists) (bool, error) {
+	return saveSessionIf(session, lists.condition(), true)
+}
+
+// appendStory adds the story to the end of the list at path, "backlog" or
+// "currentround.stories", and bumps the version of the list at versionPath.
+// The rest of the list is not rewritten, so concurrent additions are all kept.
+// Stories added by the participants count as activity of the session. It
+// reports false when no session matched condition.
+func appendStory(sessionID string, condition bson.M, path, versionPath string, story *Story, activity bool) (bool, error) {
+	set := bson.M{
+		path: bson.M{"$concatArrays": bson.A{
+			bson.M{"$ifNull": bson.A{"$" + path, bson.A{}}},
+			// Titles starting with "$" must not be read as field paths.
+			bson.M{"$literal": bson.A{story}},
+		}},
+		versionPath: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + versionPath, 0}}, 1}},
+	}
+	if activity {
+		set["lastactivityat"] = time.Now().UTC()
+	}
+	return updateSessionFields(sessionID, condition, bson.A{bson.M{"$set": set}})
+}
+
+// appendRoundStory adds the story to the end of the round, unless another
+// round was started in the meantime.
+func appendRoundStory(sessionID, roundID string, story *Story) (bool, error) {
+	return appendStory(sessionID, bson.M{"currentround.id": roundID}, "currentround.stories", "currentround.storiesversion", story, true)
+}
+
+// appendBacklogStory adds the story to the end of the backlog of the session
+// matching condition. Activity is false for stories not added by the
+// participants, like the issues sent by trackers.
+func appendBacklogStory(sessionID string, condition bson.M, story *Story, activity bool) (bool, error) {
+	return appendStory(sessionID, condition, "backlog", "backlogversion", story, activity)
+}
+
+// reorderStories returns the stories of the round in the order given by ids,
+// which has to list every story exactly once.
+func reorderStories(stories []*Story, ids []string) ([]*Story, error) {
+	if len(ids) != len(stories) {
+		return nil, fmt.Errorf("oczekiwano %d identyfikatorów, otrzymano %d", len(stories), len(ids))
+	}
+
+	byID := make(map[string]*Story, len(stories))
+	for _, story := range stories {
+		byID[story.ID] = story
+	}
+
+	ordered := make([]*Story, 0, len(ids))
+	for _, id := range ids {
+		story, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("nieznana lub powtórzona user story: %s", id)
+		}
+		ordered = append(ordered, story)
+		delete(byID, id)
+	}
+
+	return ordered, nil
+}
+
+func updateStoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	var payload struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		// Version is the story version the client edited.
+		Version *int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Version == nil {
+		http.Error(w, "Wersja user story jest wymagana", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Title != nil && *payload.Title == "" {
+		http.Error(w, "Tytuł user story jest wymagany", http.StatusBadRequest)
+		return
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	if story.Version != *payload.Version {
+		http.Error(w, "User story została w międzyczasie zmieniona", http.StatusConflict)
+		return
+	}
+
+	lists := session.storyLists()
+	if payload.Title != nil {
+		story.Title = *payload.Title
+	}
+	if payload.Description != nil {
+		story.Description = *payload.Description
+	}
+	story.Version++
+	session.CurrentRound.StoriesVersion++
+
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
+		http.Error(w, "Błąd przy zapisie user story", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "User story została w międzyczasie zmieniona", http.StatusConflict)
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/story-updated:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+func reorderStoriesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+
+	var payload struct {
+		StoryIDs []string `json:"storyIds"`
+		// Version is the stories version of the list the client reordered.
+		Version *int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Version == nil {
+		http.Error(w, "Wersja listy user stories jest wymagana", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return
+	}
+
+	if session.CurrentRound.StoriesVersion != *payload.Version {
+		http.Error(w, "Lista user stories została w międzyczasie zmieniona", http.StatusConflict)
+		return
+	}
+
+	ordered, err := reorderStories(session.CurrentRound.Stories, payload.StoryIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lists := session.storyLists()
+	session.CurrentRound.Stories = ordered
+	session.CurrentRound.StoriesVersion++
+
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
+		http.Error(w, "Błąd przy zapisie kolejności", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Lista user stories została w międzyczasie zmieniona", http.StatusConflict)
+		return
+	}
+
+	notifySessionParticipants(sessionID, "/stories-reordered")
+	session.hideVoters()
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(session.CurrentRound)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
+
+// loadCurrentStory loads the session and the story of its current round,
+// writing the error response when either is missing.
+func loadCurrentStory(w http.ResponseWriter, sessionID, storyID string) (*Session, *Story, bool) {
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	if session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	story := session.CurrentRound.findStory(storyID)
+	if story == nil {
+		http.Error(w, "User story nie znaleziona", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	return session, story, true
+}
+
+// saveStoryChange saves an edit of a story of the current round, writing the
+// error response when it fails.
+func saveStoryChange(w http.ResponseWriter, session *Session, lists storyLists) bool {
+	session.CurrentRound.StoriesVersion++
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd zapisu", http.StatusInternalServerError)
+		return false
+	}
+	if !saved {
+		http.Error(w, "Lista user stories została w międzyczasie zmieniona", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// saveSessionChange saves an action of the participants on the session loaded
+// with lists, writing the error response when it fails. The whole session is
+// written, story lists included, so the save is refused when a list was
+// changed in the meantime instead of undoing that change.
+func saveSessionChange(w http.ResponseWriter, session *Session, lists storyLists) bool {
+	saved, err := saveStoryLists(session, lists)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd zapisu", http.StatusInternalServerError)
+		return false
+	}
+	if !saved {
+		http.Error(w, "Backlog lub lista user stories zostały w międzyczasie zmienione", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func writeStory(w http.ResponseWriter, story *Story) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(story)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+func addSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+
+	var payload struct {
+		Text string `json:"text"`
+		// Task is the text sent by older clients.
+		Task     string   `json:"task"`
+		Assignee string   `json:"assignee"`
+		Hours    *float64 `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Text == "" {
+		payload.Text = payload.Task
+	}
+	if payload.Text == "" {
+		http.Error(w, "Treść zadania jest wymagana", http.StatusBadRequest)
+		return
+	}
+	if payload.Hours != nil && *payload.Hours < 0 {
+		http.Error(w, "Liczba godzin nie może być ujemna", http.StatusBadRequest)
+		return
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	lists := session.storyLists()
+	story.Subtasks = append(story.Subtasks, &Subtask{
+		ID:       uuid.New().String(),
+		Text:     payload.Text,
+		Assignee: payload.Assignee,
+		Hours:    payload.Hours,
+	})
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/subtask-added:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+func updateSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+	subtaskID := vars["subtaskId"]
+
+	var payload struct {
+		Text     *string  `json:"text"`
+		Assignee *string  `json:"assignee"`
+		Hours    *float64 `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Text != nil && *payload.Text == "" {
+		http.Error(w, "Treść zadania jest wymagana", http.StatusBadRequest)
+		return
+	}
+	if payload.Hours != nil && *payload.Hours < 0 {
+		http.Error(w, "Liczba godzin nie może być ujemna", http.StatusBadRequest)
+		return
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	_, subtask := story.findSubtask(subtaskID)
+	if subtask == nil {
+		http.Error(w, "Zadanie nie znalezione", http.StatusNotFound)
+		return
+	}
+
+	lists := session.storyLists()
+	if payload.Text != nil {
+		subtask.Text = *payload.Text
+	}
+	if payload.Assignee != nil {
+		subtask.Assignee = *payload.Assignee
+	}
+	if payload.Hours != nil {
+		subtask.Hours = payload.Hours
+	}
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/subtask-updated:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+func completeSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+	subtaskID := vars["subtaskId"]
+
+	// An empty body completes the subtask; {"done": false} reopens it.
+	payload := struct {
+		Done bool `json:"done"`
+	}{Done: true}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Błędne dane", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	_, subtask := story.findSubtask(subtaskID)
+	if subtask == nil {
+		http.Error(w, "Zadanie nie znalezione", http.StatusNotFound)
+		return
+	}
+
+	lists := session.storyLists()
+	subtask.Done = payload.Done
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/subtask-updated:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
+
+func deleteSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["id"]
+	storyID := vars["storyId"]
+	subtaskID := vars["subtaskId"]
+
+	session, story, ok := loadCurrentStory(w, sessionID, storyID)
+	if !ok {
+		return
+	}
+
+	index, _ := story.findSubtask(subtaskID)
+	if index < 0 {
+		http.Error(w, "Zadanie nie znalezione", http.StatusNotFound)
+		return
+	}
+
+	lists := session.storyLists()
+	story.Subtasks = append(story.Subtasks[:index], story.Subtasks[index+1:]...)
+
+	if !saveStoryChange(w, session, lists) {
+		return
+	}
+
+	notifySessionParticipants(sessionID, fmt.Sprintf("/subtask-removed:%s", storyID))
+	session.hideVoters()
+	writeStory(w, story)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReorderStories(t *testing.T) {
+	stories := []*Story{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	ordered, err := reorderStories(stories, []string{"c", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ordered[0].ID != "c" || ordered[1].ID != "a" || ordered[2].ID != "b" {
+		t.Errorf("nieoczekiwana kolejność: %v %v %v", ordered[0].ID, ordered[1].ID, ordered[2].ID)
+	}
+
+	invalid := [][]string{
+		{"a", "b"},
+		{"a", "b", "x"},
+		{"a", "a", "b"},
+	}
+	for _, ids := range invalid {
+		if _, err := reorderStories(stories, ids); err == nil {
+			t.Errorf("oczekiwano błędu dla %v", ids)
+		}
+	}
+}
+
+func TestStorySubtaskHoursRollUp(t *testing.T) {
+	two, half := 2.0, 0.5
+	story := &Story{
+		ID: "a",
+		Subtasks: []*Subtask{
+			{ID: "t1", Text: "Backend", Hours: &two},
+			{ID: "t2", Text: "Frontend", Hours: &half, Done: true},
+			{ID: "t3", Text: "Testy"},
+		},
+	}
+
+	data, err := json.Marshal(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		ID           string     `json:"id"`
+		SubtaskHours float64    `json:"subtaskHours"`
+		Subtasks     []*Subtask `json:"subtasks"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != "a" || len(decoded.Subtasks) != 3 {
+		t.Errorf("nieoczekiwany JSON: %s", data)
+	}
+	if decoded.SubtaskHours != 2.5 {
+		t.Errorf("oczekiwano 2.5 godziny, otrzymano %v", decoded.SubtaskHours)
+	}
+}
+
+func TestStoryListsCondition(t *testing.T) {
+	session := &Session{BacklogVersion: 2}
+	condition := session.storyLists().condition()
+	if condition["backlogversion"] != 2 || condition["currentround"] != nil {
+		t.Errorf("nieoczekiwany warunek bez rundy: %v", condition)
+	}
+	if _, ok := condition["currentround"]; !ok {
+		t.Errorf("warunek powinien wymagać braku rundy: %v", condition)
+	}
+
+	session = &Session{CurrentRound: &Round{ID: "round-1", StoriesVersion: 3}}
+	condition = session.storyLists().condition()
+	if condition["currentround.id"] != "round-1" || condition["currentround.storiesversion"] != 3 {
+		t.Errorf("nieoczekiwany warunek rundy: %v", condition)
+	}
+	if _, ok := condition["backlogversion"].(bson.M); !ok {
+		t.Errorf("wersja 0 powinna pasować też do sesji bez wersji: %v", condition["backlogversion"])
+	}
+}
+
+func TestSaveStoryListsRejectsStaleSession(t *testing.T) {
+	requireMongo(t)
+
+	session := &Session{Name: "Wersje"}
+	prepareSession(session, "")
+	startNextRound(session)
+	session.CurrentRound.Stories = []*Story{newStory("A", "", ""), newStory("B", "", "")}
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := getSession(session.ID)
+	second, _ := getSession(session.ID)
+
+	lists := first.storyLists()
+	first.CurrentRound.removeStory(first.CurrentRound.Stories[0].ID)
+	if saved, err := saveStoryLists(first, lists); err != nil || !saved {
+		t.Fatalf("pierwszy zapis powinien się udać: %v, %v", saved, err)
+	}
+
+	lists = second.storyLists()
+	ordered, _ := reorderStories(second.CurrentRound.Stories, []string{second.CurrentRound.Stories[1].ID, second.CurrentRound.Stories[0].ID})
+	second.CurrentRound.Stories = ordered
+	second.CurrentRound.StoriesVersion++
+	if saved, err := saveStoryLists(second, lists); err != nil || saved {
+		t.Errorf("zapis nieaktualnej listy powinien zostać odrzucony: %v, %v", saved, err)
+	}
+
+	// Additions do not rewrite the list, so both are kept.
+	roundID := session.CurrentRound.ID
+	for _, title := range []string{"C", "D"} {
+		if saved, err := appendRoundStory(session.ID, roundID, newStory(title, "", "")); err != nil || !saved {
+			t.Fatalf("dodanie %s nie powiodło się: %v, %v", title, saved, err)
+		}
+	}
+	if saved, err := appendBacklogStory(session.ID, nil, newStory("$title", "", ""), true); err != nil || !saved {
+		t.Fatalf("dodanie do backlogu nie powiodło się: %v, %v", saved, err)
+	}
+
+	stored, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.CurrentRound.Stories) != 3 || stored.CurrentRound.StoriesVersion != 3 {
+		t.Errorf("oczekiwano 3 stories w wersji 3, otrzymano %d w wersji %d", len(stored.CurrentRound.Stories), stored.CurrentRound.StoriesVersion)
+	}
+	if len(stored.Backlog) != 1 || stored.Backlog[0].Title != "$title" || stored.BacklogVersion != 1 {
+		t.Errorf("nieoczekiwany backlog: %+v, wersja %d", stored.Backlog, stored.BacklogVersion)
+	}
+}
+
+func TestSaveSessionChangeKeepsConcurrentStoryChange(t *testing.T) {
+	requireMongo(t)
+
+	session := &Session{Name: "Głos i zmiana listy"}
+	prepareSession(session, "")
+	startNextRound(session)
+	session.CurrentRound.Stories = []*Story{newStory("A", "", ""), newStory("B", "", "")}
+	session.CurrentRound.setActiveStory(session.CurrentRound.Stories[0].ID)
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	voting, _ := getSession(session.ID)
+	editing, _ := getSession(session.ID)
+
+	lists := editing.storyLists()
+	editing.CurrentRound.removeStory(editing.CurrentRound.Stories[1].ID)
+	if saved, err := saveStoryLists(editing, lists); err != nil || !saved {
+		t.Fatalf("usunięcie story powinno się udać: %v, %v", saved, err)
+	}
+
+	lists = voting.storyLists()
+	voting.CurrentRound.vote("Ala", 5)
+	rec := httptest.NewRecorder()
+	if saveSessionChange(rec, voting, lists) || rec.Code != http.StatusConflict {
+		t.Errorf("oczekiwano konfliktu, otrzymano %d", rec.Code)
+	}
+
+	stored, err := getSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.CurrentRound.Stories) != 1 {
+		t.Errorf("głos nie powinien przywrócić usuniętej story: %d stories", len(stored.CurrentRound.Stories))
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Team groups sessions sharing the same issue tracker credentials. Only the
+// owner can change the team or link sessions to it.
+type Team struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	OwnerID string         `json:"ownerId"`
+	Tracker *TrackerConfig `json:"tracker,omitempty"`
+	// Inbound turns labelled tracker issues into backlog stories.
+	Inbound *InboundConfig `json:"inbound,omitempty"`
+}
+
+// loadOwnedTeam loads the team and checks that the request comes from its
+// owner, writing the error response otherwise.
+func loadOwnedTeam(w http.ResponseWriter, r *http.Request, teamID string) (*Team, bool) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	team, err := getTeam(teamID)
+	if err != nil {
+		http.Error(w, "Zespół nie znaleziony", http.StatusNotFound)
+		return nil, false
+	}
+
+	if team.OwnerID != user.ID {
+		http.Error(w, "Tylko właściciel zespołu może to zrobić", http.StatusForbidden)
+		return nil, false
+	}
+
+	return team, true
+}
+
+// requireTrackerAccess checks that the request comes from someone who may use
+// the tracker of the session with the credentials of its team: the
+// facilitator, or the team owner in sessions created without logging in.
+func requireTrackerAccess(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return false
+	}
+
+	if session.FacilitatorID != "" {
+		if user.ID != session.FacilitatorID {
+			http.Error(w, "Tylko prowadzący sesję może to zrobić", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	if session.TeamID == "" {
+		return true
+	}
+	team, err := getTeam(session.TeamID)
+	if err != nil {
+		http.Error(w, "Zespół nie znaleziony", http.StatusNotFound)
+		return false
+	}
+	if team.OwnerID != user.ID {
+		http.Error(w, "Tylko właściciel zespołu może to zrobić", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeTeam(w http.ResponseWriter, team *Team) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(team)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+func createTeamHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, err := authenticate(r)
+	if err != nil {
+		http.Error(w, "Błąd weryfikacji tokenu", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Name == "" {
+		http.Error(w, "Nazwa zespołu jest wymagana", http.StatusBadRequest)
+		return
+	}
+
+	team := &Team{
+		ID:      uuid.New().String(),
+		Name:    payload.Name,
+		OwnerID: user.ID,
+	}
+
+	if err := saveTeam(team); err != nil {
+		http.Error(w, "Błąd przy zapisie zespołu", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeTeam(w, team)
+}
+
+func getTeamHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := loadOwnedTeam(w, r, mux.Vars(r)["teamId"])
+	if !ok {
+		return
+	}
+	writeTeam(w, team)
+}
+
+func updateTeamTrackerHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TrackerConfig
+		// Token is write-only. An empty token keeps the stored one.
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	team, ok := loadOwnedTeam(w, r, mux.Vars(r)["teamId"])
+	if !ok {
+		return
+	}
+
+	config := payload.TrackerConfig
+	config.Token = payload.Token
+	if config.Token == "" && team.Tracker != nil && team.Tracker.Type == config.Type {
+		config.Token = team.Tracker.Token
+	}
+
+	if _, err := newIssueTracker(&config, trackerHTTPClient); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	team.Tracker = &config
+	if err := updateTeamTracker(team); err != nil {
+		http.Error(w, "Błąd przy zapisie trackera", http.StatusInternalServerError)
+		return
+	}
+
+	writeTeam(w, team)
+}
+
+func setSessionTeamHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	var payload struct {
+		TeamID string `json:"teamId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireFacilitator(w, r, session) {
+		return
+	}
+
+	// Linking a session gives it the credentials of the team.
+	if payload.TeamID != "" {
+		if _, ok := loadOwnedTeam(w, r, payload.TeamID); !ok {
+			return
+		}
+	}
+
+	// Only the link is written, so that concurrent changes of the session
+	// are kept.
+	update := bson.M{"$set": bson.M{"teamid": payload.TeamID}}
+	if _, err := updateSessionFields(session.ID, nil, update); err != nil {
+		http.Error(w, "Wystąpił błąd zapisu", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func searchTrackerIssuesHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := getSession(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireTrackerAccess(w, r, session) {
+		return
+	}
+
+	tracker, err := trackerForSession(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	issues, err := tracker.SearchIssues(r.Context(), r.URL.Query().Get("q"))
+	if err != nil {
+		log.Printf("Błąd przy wyszukiwaniu zgłoszeń sesji %s: %v", session.ID, err)
+		http.Error(w, "Błąd połączenia z trackerem", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(issues)
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
+
+func importTrackerIssuesHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	var payload struct {
+		Keys []string `json:"keys"`
+		// Target is "backlog" (the default) or "round".
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if len(payload.Keys) == 0 || len(payload.Keys) > maxImportStories {
+		http.Error(w, fmt.Sprintf("Podaj od 1 do %d zgłoszeń", maxImportStories), http.StatusBadRequest)
+		return
+	}
+	if payload.Target == "" {
+		payload.Target = "backlog"
+	}
+	if payload.Target != "backlog" && payload.Target != "round" {
+		http.Error(w, "Nieznane miejsce importu", http.StatusBadRequest)
+		return
+	}
+
+	session, err := getSession(sessionID)
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return
+	}
+
+	if !requireTrackerAccess(w, r, session) {
+		return
+	}
+
+	if payload.Target == "round" && session.CurrentRound == nil {
+		http.Error(w, "Brak aktywnej rundy", http.StatusBadRequest)
+		return
+	}
+
+	tracker, err := trackerForSession(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsed := make([]*importedStory, 0, len(payload.Keys))
+	for _, key := range payload.Keys {
+		issue, err := tracker.GetIssue(r.Context(), key)
+		if err != nil {
+			log.Printf("Błąd przy pobieraniu zgłoszenia %s: %v", key, err)
+			http.Error(w, fmt.Sprintf("Nie udało się pobrać zgłoszenia %s", key), http.StatusBadGateway)
+			return
+		}
+		parsed = append(parsed, &importedStory{
+			Title:       issue.Title,
+			Description: issue.Description,
+			ExternalKey: issue.Key,
+		})
+	}
+
+	markDuplicates(session, parsed)
+
+	author, authorID := "", ""
+	if user, _, err := authenticate(r); err == nil {
+		author, authorID = user.Username, user.ID
+	}
+
+	lists := session.storyLists()
+	imported := addImportedStories(session, parsed, payload.Target, author, authorID)
+	if imported > 0 {
+		saved, err := saveStoryLists(session, lists)
+		if err != nil {
+			http.Error(w, "Błąd przy imporcie user stories", http.StatusInternalServerError)
+			return
+		}
+		if !saved {
+			http.Error(w, "Backlog lub lista user stories zostały w międzyczasie zmienione", http.StatusConflict)
+			return
+		}
+		notifySessionParticipants(sessionID, fmt.Sprintf("/stories-imported:%d", imported))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Stories  []*importedStory `json:"stories"`
+		Imported int              `json:"imported"`
+	}{
+		Stories:  parsed,
+		Imported: imported,
+	})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+}
+
+// syncEstimateHandler writes the final estimate of the story to the tracker
+// again, e.g. after the automatic update failed.
+func syncEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	session, story, ok := loadCurrentStory(w, vars["id"], vars["storyId"])
+	if !ok {
+		return
+	}
+
+	if !requireTrackerAccess(w, r, session) {
+		return
+	}
+
+	if story.ExternalKey == "" || story.FinalEstimate == nil {
+		http.Error(w, "User story nie ma zgłoszenia w trackerze lub estymaty", http.StatusBadRequest)
+		return
+	}
+
+	tracker, err := trackerForSession(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := pushEstimate(r.Context(), tracker, session, story); err != nil {
+		log.Printf("Błąd przy zapisie estymaty %s w trackerze: %v", story.ExternalKey, err)
+		http.Error(w, "Błąd połączenia z trackerem", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushEstimateInBackground sends the estimate to the tracker of the session
+// without delaying the response. Failures are only logged; the facilitator
+// can retry with syncEstimateHandler.
+func pushEstimateInBackground(session *Session, story *Story) {
+	if session.TeamID == "" || story.ExternalKey == "" || story.FinalEstimate == nil {
+		return
+	}
+
+	// The handler keeps using the session after it returns.
+	sessionCopy, storyCopy := *session, *story
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		tracker, err := trackerForSession(&sessionCopy)
+		if err == nil {
+			err = pushEstimate(ctx, tracker, &sessionCopy, &storyCopy)
+		}
+		if err != nil {
+			log.Printf("Błąd przy zapisie estymaty %s w trackerze: %v", story.ExternalKey, err)
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// loggedInUser saves a user and returns its Authorization header.
+func loggedInUser(t *testing.T, name string) (*User, string) {
+	t.Helper()
+	user := &User{ID: fmt.Sprintf("user-%s-%d", name, time.Now().UnixNano()), Username: fmt.Sprintf("%s%d", name, time.Now().UnixNano()), Password: "Haslo123!"}
+	token, err := generateJWT(user.ID, user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Token = token
+	if err := saveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user, "Bearer " + token
+}
+
+func TestTrackerHandlersRequireTrackerAccess(t *testing.T) {
+	requireMongo(t)
+
+	owner, ownerAuth := loggedInUser(t, "wlasciciel")
+	_, strangerAuth := loggedInUser(t, "obcy")
+
+	team := &Team{ID: fmt.Sprintf("team-%d", time.Now().UnixNano()), Name: "Zespół", OwnerID: owner.ID,
+		Tracker: &TrackerConfig{Type: "github", Owner: "acme", Repo: "app", Token: "sekret"}}
+	if err := saveTeam(team); err != nil {
+		t.Fatal(err)
+	}
+
+	withoutFacilitator := &Session{Name: "Bez konta", TeamID: team.ID}
+	prepareSession(withoutFacilitator, "")
+	withFacilitator := &Session{Name: "Z prowadzącym", TeamID: team.ID}
+	prepareSession(withFacilitator, owner.ID)
+	for _, session := range []*Session{withoutFacilitator, withFacilitator} {
+		if err := saveSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		session *Session
+		auth    string
+		want    int
+	}{
+		{withoutFacilitator, "", http.StatusUnauthorized},
+		{withoutFacilitator, strangerAuth, http.StatusForbidden},
+		{withFacilitator, "", http.StatusUnauthorized},
+		{withFacilitator, strangerAuth, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/sessions/"+c.session.ID+"/tracker/issues?q=x", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": c.session.ID})
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rr := httptest.NewRecorder()
+		searchTrackerIssuesHandler(rr, req)
+		if rr.Code != c.want {
+			t.Errorf("Wyszukiwanie w sesji %q: oczekiwano %d, otrzymano %d", c.session.Name, c.want, rr.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/sessions/"+c.session.ID+"/tracker/import", strings.NewReader(`{"keys": ["acme/app#1"]}`))
+		req = mux.SetURLVars(req, map[string]string{"id": c.session.ID})
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rr = httptest.NewRecorder()
+		importTrackerIssuesHandler(rr, req)
+		if rr.Code != c.want {
+			t.Errorf("Import w sesji %q: oczekiwano %d, otrzymano %d", c.session.Name, c.want, rr.Code)
+		}
+	}
+
+	if stored, _ := getSession(withoutFacilitator.ID); len(stored.Backlog) != 0 {
+		t.Errorf("Import bez uprawnień dodał user stories: %+v", stored.Backlog)
+	}
+
+	rr := httptest.NewRecorder()
+	if !requireTrackerAccess(rr, authorizedRequest(ownerAuth), withoutFacilitator) {
+		t.Errorf("Właściciel zespołu powinien mieć dostęp do trackera, otrzymano %d", rr.Code)
+	}
+}
+
+func authorizedRequest(auth string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", auth)
+	return req
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Issue is an issue of an external tracker that can become a story.
+type Issue struct {
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// IssueTracker is the connection to the tracker of a team, used to import
+// issues as stories and to write the agreed estimates back.
+type IssueTracker interface {
+	SearchIssues(ctx context.Context, query string) ([]Issue, error)
+	GetIssue(ctx context.Context, key string) (*Issue, error)
+	SetStoryPoints(ctx context.Context, key string, points int) error
+	AddComment(ctx context.Context, key, body string) error
+}
+
+const (
+	trackerJira   = "jira"
+	trackerGitHub = "github"
+)
+
+// TrackerConfig holds the tracker connection of a team. The token is stored
+// but never sent back to clients.
+type TrackerConfig struct {
+	Type    string `json:"type"`
+	BaseURL string `json:"baseUrl,omitempty"`
+	// Project is the Jira project key searches are limited to.
+	Project string `json:"project,omitempty"`
+	// Owner and Repo name the GitHub repository.
+	Owner string `json:"owner,omitempty"`
+	Repo  string `json:"repo,omitempty"`
+	// Username is the Jira account e-mail used with the API token.
+	Username string `json:"username,omitempty"`
+	Token    string `json:"-"`
+	// StoryPointsField is the Jira custom field holding story points.
+	StoryPointsField string `json:"storyPointsField,omitempty"`
+}
+
+var errNoTracker = errors.New("zespół nie ma skonfigurowanego trackera")
+
+var trackerHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+func newIssueTracker(config *TrackerConfig, client *http.Client) (IssueTracker, error) {
+	if config == nil {
+		return nil, errNoTracker
+	}
+
+	switch config.Type {
+	case trackerJira:
+		if config.BaseURL == "" || config.StoryPointsField == "" {
+			return nil, errors.New("tracker Jira wymaga adresu i pola story points")
+		}
+		return &jiraTracker{config: *config, client: client}, nil
+	case trackerGitHub:
+		if config.Owner == "" || config.Repo == "" {
+			return nil, errors.New("tracker GitHub wymaga właściciela i nazwy repozytorium")
+		}
+		if config.BaseURL == "" {
+			config.BaseURL = "https://api.github.com"
+		}
+		return &githubTracker{config: *config, client: client}, nil
+	}
+	return nil, fmt.Errorf("nieobsługiwany tracker: %q", config.Type)
+}
+
+// trackerForSession returns the tracker of the team the session belongs to.
+func trackerForSession(session *Session) (IssueTracker, error) {
+	if session.TeamID == "" {
+		return nil, errNoTracker
+	}
+	team, err := getTeam(session.TeamID)
+	if err != nil {
+		return nil, err
+	}
+	return newIssueTracker(team.Tracker, trackerHTTPClient)
+}
+
+// trackerError is returned for responses outside the 2xx range.
+type trackerError struct {
+	Status int
+	Body   string
+}
+
+func (e *trackerError) Error() string {
+	return fmt.Sprintf("tracker odpowiedział %d: %s", e.Status, e.Body)
+}
+
+// doTrackerRequest sends the request with an optional JSON body and decodes
+// the JSON response into out when it is not nil.
+func doTrackerRequest(ctx context.Context, client *http.Client, method, url string, authorize func(*http.Request), body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	authorize(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &trackerError{Status: resp.StatusCode, Body: string(data)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// estimateComment is the comment added to the issue with the final estimate.
+func estimateComment(session *Session, story *Story) string {
+	comment := fmt.Sprintf("Estymata z Kat Poker (%s): %d SP", session.Name, *story.FinalEstimate)
+	if story.EstimateNote != "" {
+		comment += "\n\n" + story.EstimateNote
+	}
+	return comment
+}
+
+// pushEstimate writes the final estimate of the story to its issue.
+func pushEstimate(ctx context.Context, tracker IssueTracker, session *Session, story *Story) error {
+	if story.ExternalKey == "" || story.FinalEstimate == nil {
+		return nil
+	}
+	if err := tracker.SetStoryPoints(ctx, story.ExternalKey, *story.FinalEstimate); err != nil {
+		return err
+	}
+	return tracker.AddComment(ctx, story.ExternalKey, estimateComment(session, story))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// trackerRequest is a request received by the mock tracker.
+type trackerRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+	Auth   string
+}
+
+// mockTracker serves canned responses keyed by "METHOD path" and records every
+// request it receives.
+func mockTracker(t *testing.T, responses map[string]string) (*httptest.Server, *[]trackerRequest) {
+	t.Helper()
+	var requests []trackerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, trackerRequest{
+			Method: r.Method,
+			Path:   r.URL.EscapedPath(),
+			Query:  r.URL.RawQuery,
+			Body:   string(body),
+			Auth:   r.Header.Get("Authorization"),
+		})
+
+		response, ok := responses[r.Method+" "+r.URL.EscapedPath()]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestJiraTracker(t *testing.T) {
+	server, requests := mockTracker(t, map[string]string{
+		"GET /rest/api/2/search":              `{"issues":[{"key":"KP-1","fields":{"summary":"Logowanie","description":"Przez SSO"}}]}`,
+		"PUT /rest/api/2/issue/KP-1":          ``,
+		"POST /rest/api/2/issue/KP-1/comment": `{}`,
+	})
+
+	tracker, err := newIssueTracker(&TrackerConfig{
+		Type:             trackerJira,
+		BaseURL:          server.URL,
+		Project:          "KP",
+		Username:         "ala@example.com",
+		Token:            "sekret",
+		StoryPointsField: "customfield_10016",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := tracker.SearchIssues(context.Background(), "logowanie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Key != "KP-1" || issues[0].URL != server.URL+"/browse/KP-1" {
+		t.Errorf("nieoczekiwane zgłoszenia: %+v", issues)
+	}
+	search := (*requests)[0]
+	if !strings.Contains(search.Query, "project+%3D+%22KP%22+AND+text+~+%22logowanie%22") {
+		t.Errorf("nieoczekiwane zapytanie JQL: %s", search.Query)
+	}
+	if !strings.HasPrefix(search.Auth, "Basic ") {
+		t.Errorf("oczekiwano uwierzytelnienia Basic, otrzymano %q", search.Auth)
+	}
+
+	five := 5
+	session := &Session{Name: "Sprint 7"}
+	story := &Story{ExternalKey: "KP-1", FinalEstimate: &five, EstimateNote: "Bez SSO"}
+	if err := pushEstimate(context.Background(), tracker, session, story); err != nil {
+		t.Fatal(err)
+	}
+
+	update := (*requests)[1]
+	if update.Method != "PUT" || update.Body != `{"fields":{"customfield_10016":5}}` {
+		t.Errorf("nieoczekiwana aktualizacja: %+v", update)
+	}
+	comment := (*requests)[2]
+	if !strings.Contains(comment.Body, "5 SP") || !strings.Contains(comment.Body, "Bez SSO") {
+		t.Errorf("nieoczekiwany komentarz: %s", comment.Body)
+	}
+}
+
+func TestJiraTrackerReportsErrors(t *testing.T) {
+	server, _ := mockTracker(t, nil)
+	tracker, _ := newIssueTracker(&TrackerConfig{Type: trackerJira, BaseURL: server.URL, StoryPointsField: "sp"}, server.Client())
+
+	_, err := tracker.GetIssue(context.Background(), "KP-404")
+	var trackerErr *trackerError
+	if !errors.As(err, &trackerErr) || trackerErr.Status != http.StatusNotFound {
+		t.Errorf("oczekiwano błędu 404, otrzymano %v", err)
+	}
+}
+
+func TestGitHubTracker(t *testing.T) {
+	server, requests := mockTracker(t, map[string]string{
+		"GET /search/issues": `{"items":[
+			{"number":12,"title":"Logowanie","body":"Przez SSO","html_url":"https://github.com/kat/poker/issues/12"},
+			{"number":13,"title":"PR","pull_request":{}}
+		]}`,
+		"GET /repos/kat/poker/issues/12":                       `{"number":12,"title":"Logowanie","labels":[{"name":"points: 3"},{"name":"bug"}]}`,
+		"DELETE /repos/kat/poker/issues/12/labels/points:%203": `[]`,
+		"POST /repos/kat/poker/issues/12/labels":               `[]`,
+		"POST /repos/kat/poker/issues/12/comments":             `{}`,
+	})
+
+	tracker, err := newIssueTracker(&TrackerConfig{
+		Type:    trackerGitHub,
+		BaseURL: server.URL,
+		Owner:   "kat",
+		Repo:    "poker",
+		Token:   "ghp_sekret",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := tracker.SearchIssues(context.Background(), "logowanie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Key != "kat/poker#12" {
+		t.Errorf("oczekiwano jednego zgłoszenia bez pull requestów, otrzymano %+v", issues)
+	}
+	if (*requests)[0].Auth != "Bearer ghp_sekret" {
+		t.Errorf("nieoczekiwany nagłówek autoryzacji: %q", (*requests)[0].Auth)
+	}
+
+	five := 5
+	story := &Story{ExternalKey: "kat/poker#12", FinalEstimate: &five}
+	if err := pushEstimate(context.Background(), tracker, &Session{Name: "Sprint 7"}, story); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	for _, req := range (*requests)[1:] {
+		calls = append(calls, req.Method+" "+req.Path)
+	}
+	want := []string{
+		"GET /repos/kat/poker/issues/12",
+		"DELETE /repos/kat/poker/issues/12/labels/points:%203",
+		"POST /repos/kat/poker/issues/12/labels",
+		"POST /repos/kat/poker/issues/12/comments",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("nieoczekiwane wywołania:\n%s", strings.Join(calls, "\n"))
+	}
+
+	var labels struct {
+		Labels []string `json:"labels"`
+	}
+	if err := json.Unmarshal([]byte((*requests)[3].Body), &labels); err != nil || labels.Labels[0] != "points: 5" {
+		t.Errorf("nieoczekiwane etykiety: %s", (*requests)[3].Body)
+	}
+
+	count := len(*requests)
+	story.ExternalKey = "kat/inne#12"
+	if err := pushEstimate(context.Background(), tracker, &Session{Name: "Sprint 7"}, story); err == nil {
+		t.Error("zgłoszenie z innego repozytorium powinno zostać odrzucone")
+	}
+	if len(*requests) != count {
+		t.Errorf("zgłoszenie z innego repozytorium zostało zmienione: %+v", (*requests)[count:])
+	}
+	for _, key := range []string{"12", "#12", "Kat/Poker#12"} {
+		if number, err := tracker.(*githubTracker).issueNumber(key); err != nil || number != 12 {
+			t.Errorf("klucz %q: oczekiwano 12, otrzymano %d, %v", key, number, err)
+		}
+	}
+}
+
+func TestNewIssueTrackerValidatesConfig(t *testing.T) {
+	if _, err := newIssueTracker(nil, nil); !errors.Is(err, errNoTracker) {
+		t.Errorf("oczekiwano errNoTracker, otrzymano %v", err)
+	}
+	if _, err := newIssueTracker(&TrackerConfig{Type: trackerJira, BaseURL: "https://jira"}, nil); err == nil {
+		t.Error("Jira bez pola story points powinna zwrócić błąd")
+	}
+	if _, err := newIssueTracker(&TrackerConfig{Type: "trello"}, nil); err == nil {
+		t.Error("nieznany tracker powinien zwrócić błąd")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// playerVoteStats summarizes how a player voted on a single story of a round.
+type playerVoteStats struct {
+	Player  string `json:"player"`
+	StoryID string `json:"storyId"`
+	// Iteration is the vote on the story the stats belong to, numbered from 1
+	// like the iterations archived by re-votes.
+	Iteration int `json:"iteration"`
+	// SecondsToFirstVote counts from the moment voting on the story opened,
+	// or from the start of the round for rounds recorded without that event.
+	SecondsToFirstVote float64 `json:"secondsToFirstVote"`
+	// Changes counts votes that replaced an earlier, different value.
+	Changes     int `json:"changes"`
+	Retractions int `json:"retractions"`
+}
+
+// computeVoteStats walks the audit trail of the round and returns the stats of
+// every player for every story and iteration they voted on, in the order of
+// first votes. A re-vote starts the next iteration, so its votes are counted
+// from the moment voting opened again and are not changes of earlier votes.
+func computeVoteStats(round *Round) []*playerVoteStats {
+	stats := []*playerVoteStats{}
+	byKey := make(map[string]*playerVoteStats)
+	opened := make(map[string]time.Time)
+	lastValue := make(map[string]int)
+	iterations := make(map[string]int)
+
+	for _, event := range round.VoteEvents {
+		iteration := iterations[event.StoryID] + 1
+		storyKey := fmt.Sprintf("%s\x00%d", event.StoryID, iteration)
+
+		switch event.Type {
+		case voteEventArchived:
+			iterations[event.StoryID]++
+			continue
+		case voteEventOpened:
+			if _, ok := opened[storyKey]; !ok {
+				opened[storyKey] = event.At
+			}
+			continue
+		}
+
+		key := storyKey + "\x00" + event.Player
+		stat, ok := byKey[key]
+		if !ok {
+			if event.Type != voteEventVoted {
+				continue
+			}
+			start, ok := opened[storyKey]
+			if !ok {
+				start = round.StartedAt
+			}
+			stat = &playerVoteStats{Player: event.Player, StoryID: event.StoryID, Iteration: iteration}
+			if !start.IsZero() {
+				stat.SecondsToFirstVote = event.At.Sub(start).Seconds()
+			}
+			byKey[key] = stat
+			stats = append(stats, stat)
+			lastValue[key] = *event.Value
+			continue
+		}
+
+		switch event.Type {
+		case voteEventVoted:
+			if *event.Value != lastValue[key] {
+				stat.Changes++
+			}
+			lastValue[key] = *event.Value
+		case voteEventRetract:
+			stat.Retractions++
+		}
+	}
+
+	return stats
+}
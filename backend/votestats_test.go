@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeVoteStats(t *testing.T) {
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	three, five := 3, 5
+	round := &Round{
+		ID:        "round-1",
+		StartedAt: start,
+		VoteEvents: []*VoteEvent{
+			{Type: voteEventOpened, StoryID: "a", At: start.Add(10 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &three, At: start.Add(40 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Jacek", Value: &five, At: start.Add(70 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &five, At: start.Add(80 * time.Second)},
+			{Type: voteEventRetract, StoryID: "a", Player: "Ala", At: start.Add(90 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &five, At: start.Add(95 * time.Second)},
+			{Type: voteEventRetract, StoryID: "b", Player: "Jacek", At: start.Add(100 * time.Second)},
+		},
+	}
+
+	stats := computeVoteStats(round)
+	if len(stats) != 2 {
+		t.Fatalf("oczekiwano statystyk dla 2 graczy, otrzymano %d", len(stats))
+	}
+
+	ala := stats[0]
+	if ala.Player != "Ala" || ala.SecondsToFirstVote != 30 {
+		t.Errorf("nieoczekiwane statystyki Ali: %+v", ala)
+	}
+	if ala.Changes != 1 || ala.Retractions != 1 {
+		t.Errorf("oczekiwano 1 zmiany i 1 wycofania, otrzymano %+v", ala)
+	}
+
+	if jacek := stats[1]; jacek.Player != "Jacek" || jacek.SecondsToFirstVote != 60 || jacek.Changes != 0 {
+		t.Errorf("nieoczekiwane statystyki Jacka: %+v", jacek)
+	}
+}
+
+func TestComputeVoteStatsAfterRevote(t *testing.T) {
+	start := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	three, five, eight := 3, 5, 8
+	round := &Round{
+		ID:        "round-1",
+		StartedAt: start,
+		VoteEvents: []*VoteEvent{
+			{Type: voteEventOpened, StoryID: "a", At: start},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &three, At: start.Add(10 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &five, At: start.Add(20 * time.Second)},
+			{Type: voteEventArchived, StoryID: "a", At: start.Add(60 * time.Second)},
+			{Type: voteEventOpened, StoryID: "a", At: start.Add(60 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &eight, At: start.Add(75 * time.Second)},
+			// Voting on another story and coming back does not start a new
+			// iteration.
+			{Type: voteEventOpened, StoryID: "b", At: start.Add(80 * time.Second)},
+			{Type: voteEventOpened, StoryID: "a", At: start.Add(90 * time.Second)},
+			{Type: voteEventVoted, StoryID: "a", Player: "Ala", Value: &eight, At: start.Add(95 * time.Second)},
+		},
+	}
+
+	stats := computeVoteStats(round)
+	if len(stats) != 2 {
+		t.Fatalf("oczekiwano statystyk dla 2 iteracji, otrzymano %d", len(stats))
+	}
+	if first := stats[0]; first.Iteration != 1 || first.SecondsToFirstVote != 10 || first.Changes != 1 {
+		t.Errorf("nieoczekiwane statystyki pierwszej iteracji: %+v", first)
+	}
+	if second := stats[1]; second.Iteration != 2 || second.SecondsToFirstVote != 15 || second.Changes != 0 {
+		t.Errorf("nieoczekiwane statystyki drugiej iteracji: %+v", second)
+	}
+}
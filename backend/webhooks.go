@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookSubscription sends the events of one session, or of all sessions
+// when SessionID is empty, to an external URL.
+type WebhookSubscription struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId,omitempty"`
+	URL       string `json:"url"`
+	// Events filters the event types; an empty list means all of them.
+	Events []string `json:"events"`
+	// Secret signs the payloads. It is shown only once, when created.
+	Secret    string    `json:"-"`
+	OwnerID   string    `json:"ownerId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (s *WebhookSubscription) wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, t := range s.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event queued for one subscription. It stays in the
+// queue until the receiver answers with 2xx or the attempts run out.
+type WebhookDelivery struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscriptionId"`
+	SessionID      string `json:"sessionId"`
+	EventID        string `json:"eventId"`
+	Event          string `json:"event"`
+	// Payload is the request body exactly as it is signed and sent.
+	Payload       string             `json:"payload"`
+	Status        string             `json:"status"`
+	Attempts      []*DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt"`
+	CreatedAt     time.Time          `json:"createdAt"`
+}
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+const (
+	maxWebhookAttempts   = 8
+	webhookRetryBase     = 30 * time.Second
+	webhookRetryMax      = 6 * time.Hour
+	webhookLease         = 2 * time.Minute
+	webhookPollInterval  = 5 * time.Second
+	webhookDeliveryLimit = 50
+)
+
+// webhookRetryDelay is the wait after the given failed attempt: 30s, 1m, 2m
+// and so on, doubling up to webhookRetryMax.
+func webhookRetryDelay(attempt int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+	return delay
+}
+
+// signWebhookPayload returns the X-KatPoker-Signature value. The timestamp is
+// signed together with the body so that receivers can reject replays.
+func signWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+type webhookStore interface {
+	CreateSubscription(ctx context.Context, sub *WebhookSubscription) error
+	DeleteSubscription(ctx context.Context, id string) error
+	Subscription(ctx context.Context, id string) (*WebhookSubscription, error)
+	// Subscriptions returns the subscriptions of the session, or the global
+	// ones for an empty sessionID.
+	Subscriptions(ctx context.Context, sessionID string) ([]*WebhookSubscription, error)
+	EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// ClaimDelivery returns a pending delivery due at now and postpones it by
+	// the lease, so that no other worker picks it up meanwhile. It returns
+	// nil when nothing is due.
+	ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// Deliveries returns the latest deliveries of the subscription.
+	Deliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error)
+}
+
+var errWebhookNotFound = errors.New("webhook nie znaleziony")
+
+// webhooks is set in main once MongoDB is connected.
+var webhooks webhookStore
+
+// webhookWake lets the dispatcher send new deliveries right away instead of
+// waiting for the next poll.
+var webhookWake = make(chan struct{}, 1)
+
+// enqueueWebhooks queues the event for every subscription of its session and
+// every global subscription that wants it.
+func enqueueWebhooks(store webhookStore, event Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subs, err := store.Subscriptions(ctx, event.SessionID)
+	if err != nil {
+		return err
+	}
+	global, err := store.Subscriptions(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	queued := 0
+	for _, sub := range append(subs, global...) {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: sub.ID,
+			SessionID:      event.SessionID,
+			EventID:        event.ID,
+			Event:          event.Type,
+			Payload:        string(payload),
+			Status:         deliveryPending,
+			Attempts:       []*DeliveryAttempt{},
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		if err := store.EnqueueDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+type webhookDispatcher struct {
+	store  webhookStore
+	client *http.Client
+	now    func() time.Time
+}
+
+func newWebhookDispatcher(store webhookStore) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:  store,
+		client: newWebhookClient(),
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+}
+
+var errWebhookAddress = errors.New("adres webhooka prowadzi do sieci wewnętrznej")
+
+// internalPrefixes are the address ranges reserved for the internal network
+// and for special uses, which may not receive webhooks.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network, reaches local services
+	netip.MustParsePrefix("10.0.0.0/8"),     // private
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT, internal on many hosts
+	netip.MustParsePrefix("127.0.0.0/8"),    // loopback
+	netip.MustParsePrefix("169.254.0.0/16"), // link-local, with the cloud metadata service
+	netip.MustParsePrefix("172.16.0.0/12"),  // private
+	netip.MustParsePrefix("192.168.0.0/16"), // private
+	netip.MustParsePrefix("224.0.0.0/4"),    // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved and broadcast
+	netip.MustParsePrefix("::/128"),         // unspecified
+	netip.MustParsePrefix("::1/128"),        // loopback
+	netip.MustParsePrefix("fc00::/7"),       // unique local
+	netip.MustParsePrefix("fe80::/10"),      // link-local
+	netip.MustParsePrefix("ff00::/8"),       // multicast
+}
+
+// publicAddress reports whether the address may receive webhooks, that is
+// whether it is outside internalPrefixes. IPv4 addresses written as IPv6 are
+// checked as IPv4.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookHost resolves the host and rejects it when any of its addresses
+// is not public.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("nie można odnaleźć hosta %s", host)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return errWebhookAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns a client that checks every address it connects to,
+// so a host resolving to the internal network after it was validated is still
+// refused. Redirects are not followed; they count as a failed delivery.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if addr, err := netip.ParseAddr(host); err != nil || !publicAddress(addr) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// run delivers due webhooks until the context is cancelled. Deliveries live in
+// the database, so the ones not sent before a restart are picked up again.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// deliverDue sends every delivery that is due and returns how many it tried.
+func (d *webhookDispatcher) deliverDue(ctx context.Context) int {
+	sent := 0
+	for ctx.Err() == nil {
+		delivery, err := d.store.ClaimDelivery(ctx, d.now(), webhookLease)
+		if err != nil {
+			log.Printf("Błąd przy pobieraniu webhooków do wysłania: %v", err)
+			return sent
+		}
+		if delivery == nil {
+			return sent
+		}
+		d.deliver(ctx, delivery)
+		sent++
+	}
+	return sent
+}
+
+func (d *webhookDispatcher) deliver(ctx context.Context, delivery *WebhookDelivery) {
+	attempt := &DeliveryAttempt{At: d.now()}
+
+	sub, err := d.store.Subscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		// The subscription was deleted after the event was queued.
+		attempt.Error = err.Error()
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = deliveryFailed
+		d.save(ctx, delivery)
+		return
+	}
+
+	started := time.Now()
+	attempt.StatusCode, err = d.post(ctx, sub, delivery)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case err == nil:
+		delivery.Status = deliveryDelivered
+	case len(delivery.Attempts) >= maxWebhookAttempts:
+		delivery.Status = deliveryFailed
+		log.Printf("Webhook %s do %s nie został dostarczony: %v", delivery.ID, sub.URL, err)
+	default:
+		delivery.NextAttemptAt = attempt.At.Add(webhookRetryDelay(len(delivery.Attempts)))
+	}
+	d.save(ctx, delivery)
+}
+
+func (d *webhookDispatcher) post(ctx context.Context, sub *WebhookSubscription, delivery *WebhookDelivery) (int, error) {
+	timestamp := d.now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "KatPoker-Webhooks")
+	req.Header.Set("X-KatPoker-Event", delivery.Event)
+	req.Header.Set("X-KatPoker-Delivery", delivery.ID)
+	req.Header.Set("X-KatPoker-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-KatPoker-Signature", signWebhookPayload(sub.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("odpowiedź %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *webhookDispatcher) save(ctx context.Context, delivery *WebhookDelivery) {
+	if err := d.store.UpdateDelivery(ctx, delivery); err != nil {
+		log.Printf("Błąd przy zapisie webhooka %s: %v", delivery.ID, err)
+	}
+}
+
+// startWebhooks connects the webhooks to the event bus and starts delivering
+// them in the background.
+func startWebhooks(store webhookStore) {
+	webhooks = store
+	subscribeEvents(func(event Event) {
+		if err := enqueueWebhooks(store, event); err != nil {
+			log.Printf("Błąd przy kolejkowaniu webhooków zdarzenia %s: %v", event.Type, err)
+		}
+	})
+	go newWebhookDispatcher(store).run(context.Background())
+}
+
+type mongoWebhookStore struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+func (s *mongoWebhookStore) CreateSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	_, err := s.subscriptions.InsertOne(ctx, sub)
+	return err
+}
+
+func (s *mongoWebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	result, err := s.subscriptions.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+func (s *mongoWebhookStore) Subscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	var sub WebhookSubscription
+	err := s.subscriptions.FindOne(ctx, bson.M{"id": id}).Decode(&sub)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *mongoWebhookStore) Subscriptions(ctx context.Context, sessionID string) ([]*WebhookSubscription, error) {
+	cursor, err := s.subscriptions.Find(ctx, bson.M{"sessionid": sessionID})
+	if err != nil {
+		return nil, err
+	}
+	subs := []*WebhookSubscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (s *mongoWebhookStore) EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := s.deliveries.InsertOne(ctx, delivery)
+	return err
+}
+
+func (s *mongoWebhookStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*WebhookDelivery, error) {
+	filter := bson.M{"status": deliveryPending, "nextattemptat": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"nextattemptat": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextattemptat", Value: 1}})
+
+	var delivery WebhookDelivery
+	err := s.deliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (s *mongoWebhookStore) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := s.deliveries.ReplaceOne(ctx, bson.M{"id": delivery.ID}, delivery)
+	return err
+}
+
+func (s *mongoWebhookStore) Deliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdat", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := s.deliveries.Find(ctx, bson.M{"subscriptionid": subscriptionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []*WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// requireWebhookAdmin guards the global subscriptions, which see the events of
+// every session. They are managed with the WEBHOOK_ADMIN_TOKEN and disabled
+// when it is not set.
+func requireWebhookAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("WEBHOOK_ADMIN_TOKEN")
+	given := r.Header.Get("X-Admin-Token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+		http.Error(w, "Brak uprawnień do globalnych webhooków", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireWebhookStore writes an error when webhooks are not running.
+func requireWebhookStore(w http.ResponseWriter) bool {
+	if webhooks == nil {
+		http.Error(w, "Webhooki są wyłączone", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func validateWebhookPayload(ctx context.Context, rawURL string, events []string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("podaj adres URL zaczynający się od http:// lub https://")
+	}
+	for _, event := range events {
+		if !validEventType(event) {
+			return fmt.Errorf("nieznany typ zdarzenia: %s", event)
+		}
+	}
+	return checkWebhookHost(ctx, parsed.Hostname())
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request, sessionID, ownerID string) {
+	var payload struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Błędne dane", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateWebhookPayload(r.Context(), payload.URL, payload.Events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+		return
+	}
+
+	if payload.Events == nil {
+		payload.Events = []string{}
+	}
+	sub := &WebhookSubscription{
+		ID:        uuid.New().String(),
+		SessionID: sessionID,
+		URL:       payload.URL,
+		Events:    payload.Events,
+		Secret:    secret,
+		OwnerID:   ownerID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := webhooks.CreateSubscription(r.Context(), sub); err != nil {
+		http.Error(w, "Błąd przy zapisie webhooka", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(struct {
+		*WebhookSubscription
+		Secret string `json:"secret"`
+	}{sub, sub.Secret})
+	if err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, sessionID string) {
+	subs, err := webhooks.Subscriptions(r.Context(), sessionID)
+	if err != nil {
+		http.Error(w, "Błąd przy pobieraniu webhooków", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// loadWebhook returns the subscription only when it belongs to the session,
+// so that a facilitator cannot reach the webhooks of other sessions.
+func loadWebhook(w http.ResponseWriter, r *http.Request, sessionID, webhookID string) (*WebhookSubscription, bool) {
+	sub, err := webhooks.Subscription(r.Context(), webhookID)
+	if err != nil || sub.SessionID != sessionID {
+		http.Error(w, "Webhook nie znaleziony", http.StatusNotFound)
+		return nil, false
+	}
+	return sub, true
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request, sessionID string) {
+	sub, ok := loadWebhook(w, r, sessionID, mux.Vars(r)["webhookId"])
+	if !ok {
+		return
+	}
+
+	if err := webhooks.DeleteSubscription(r.Context(), sub.ID); err != nil {
+		http.Error(w, "Błąd przy usuwaniu webhooka", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request, sessionID string) {
+	sub, ok := loadWebhook(w, r, sessionID, mux.Vars(r)["webhookId"])
+	if !ok {
+		return
+	}
+
+	deliveries, err := webhooks.Deliveries(r.Context(), sub.ID, webhookDeliveryLimit)
+	if err != nil {
+		http.Error(w, "Błąd przy pobieraniu dostaw webhooka", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		http.Error(w, "Wystąpił błąd", http.StatusInternalServerError)
+	}
+}
+
+// loadSessionForWebhooks loads the session and checks that the request comes
+// from its facilitator. Sessions created without an account have no
+// facilitator to check, so they cannot have webhooks.
+func loadSessionForWebhooks(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	if !requireWebhookStore(w) {
+		return nil, false
+	}
+
+	session, err := getSession(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Sesja nie znaleziona", http.StatusNotFound)
+		return nil, false
+	}
+
+	if session.FacilitatorID == "" {
+		http.Error(w, "Webhooki są dostępne tylko w sesjach założonych przez zalogowanego prowadzącego", http.StatusForbidden)
+		return nil, false
+	}
+	if !requireFacilitator(w, r, session) {
+		return nil, false
+	}
+	return session, true
+}
+
+func createSessionWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := loadSessionForWebhooks(w, r)
+	if !ok {
+		return
+	}
+	createWebhook(w, r, session.ID, session.FacilitatorID)
+}
+
+func listSessionWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := loadSessionForWebhooks(w, r); ok {
+		listWebhooks(w, r, session.ID)
+	}
+}
+
+func deleteSessionWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := loadSessionForWebhooks(w, r); ok {
+		deleteWebhook(w, r, session.ID)
+	}
+}
+
+func sessionWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := loadSessionForWebhooks(w, r); ok {
+		listWebhookDeliveries(w, r, session.ID)
+	}
+}
+
+func createGlobalWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if requireWebhookStore(w) && requireWebhookAdmin(w, r) {
+		createWebhook(w, r, "", "")
+	}
+}
+
+func listGlobalWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if requireWebhookStore(w) && requireWebhookAdmin(w, r) {
+		listWebhooks(w, r, "")
+	}
+}
+
+func deleteGlobalWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if requireWebhookStore(w) && requireWebhookAdmin(w, r) {
+		deleteWebhook(w, r, "")
+	}
+}
+
+func globalWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if requireWebhookStore(w) && requireWebhookAdmin(w, r) {
+		listWebhookDeliveries(w, r, "")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memoryWebhookStore keeps subscriptions and deliveries in memory.
+type memoryWebhookStore struct {
+	mu            sync.Mutex
+	subscriptions map[string]*WebhookSubscription
+	deliveries    []*WebhookDelivery
+}
+
+func newMemoryWebhookStore(subs ...*WebhookSubscription) *memoryWebhookStore {
+	store := &memoryWebhookStore{subscriptions: make(map[string]*WebhookSubscription)}
+	for _, sub := range subs {
+		store.subscriptions[sub.ID] = sub
+	}
+	return store
+}
+
+func (s *memoryWebhookStore) CreateSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (s *memoryWebhookStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return errWebhookNotFound
+	}
+	delete(s.subscriptions, id)
+	return nil
+}
+
+func (s *memoryWebhookStore) Subscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return nil, errWebhookNotFound
+	}
+	return sub, nil
+}
+
+func (s *memoryWebhookStore) Subscriptions(ctx context.Context, sessionID string) ([]*WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := []*WebhookSubscription{}
+	for _, sub := range s.subscriptions {
+		if sub.SessionID == sessionID {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs, nil
+}
+
+func (s *memoryWebhookStore) EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryWebhookStore) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delivery := range s.deliveries {
+		if delivery.Status == deliveryPending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = now.Add(lease)
+			copied := *delivery
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryWebhookStore) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.deliveries {
+		if existing.ID == delivery.ID {
+			s.deliveries[i] = delivery
+		}
+	}
+	return nil
+}
+
+func (s *memoryWebhookStore) Deliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []*WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID == subscriptionID && len(deliveries) < limit {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	payload := []byte(`{"type":"round.started"}`)
+
+	signature := signWebhookPayload("sekret", 1700000000, payload)
+	if signature != signWebhookPayload("sekret", 1700000000, payload) {
+		t.Errorf("Podpis nie jest deterministyczny")
+	}
+	if len(signature) != len("sha256=")+64 || signature[:7] != "sha256=" {
+		t.Errorf("Nieprawidłowy format podpisu: %s", signature)
+	}
+	if signature == signWebhookPayload("inny", 1700000000, payload) {
+		t.Errorf("Podpis nie zależy od sekretu")
+	}
+	if signature == signWebhookPayload("sekret", 1700000001, payload) {
+		t.Errorf("Podpis nie zależy od znacznika czasu")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		20: webhookRetryMax,
+	}
+	for attempt, want := range cases {
+		if got := webhookRetryDelay(attempt); got != want {
+			t.Errorf("Opóźnienie po próbie %d: oczekiwano %v, otrzymano %v", attempt, want, got)
+		}
+	}
+}
+
+func TestEnqueueWebhooksFiltersEvents(t *testing.T) {
+	store := newMemoryWebhookStore(
+		&WebhookSubscription{ID: "a", SessionID: "session-1", Events: []string{eventStoryEstimated}},
+		&WebhookSubscription{ID: "b", SessionID: "session-1", Events: []string{eventRoundStarted}},
+		&WebhookSubscription{ID: "c", SessionID: "session-2"},
+		&WebhookSubscription{ID: "d"},
+	)
+
+	event := Event{ID: "e1", Type: eventRoundStarted, SessionID: "session-1"}
+	if err := enqueueWebhooks(store, event); err != nil {
+		t.Fatalf("Błąd kolejkowania: %v", err)
+	}
+
+	var queued []string
+	for _, delivery := range store.deliveries {
+		queued = append(queued, delivery.SubscriptionID)
+		if delivery.Status != deliveryPending || delivery.Event != eventRoundStarted {
+			t.Errorf("Nieprawidłowa dostawa: %+v", delivery)
+		}
+	}
+	sort.Strings(queued)
+	if len(queued) != 2 || queued[0] != "b" || queued[1] != "d" {
+		t.Errorf("Oczekiwano dostaw dla b i d, otrzymano %v", queued)
+	}
+}
+
+func TestWebhookDispatcherDeliversSignedPayload(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers http.Header
+		body    []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	store := newMemoryWebhookStore(&WebhookSubscription{ID: "a", SessionID: "session-1", URL: server.URL, Secret: "sekret"})
+	event := Event{ID: "e1", Type: eventStoryEstimated, SessionID: "session-1", Data: storyEstimatedData{StoryID: "s1", FinalEstimate: 5}}
+	if err := enqueueWebhooks(store, event); err != nil {
+		t.Fatalf("Błąd kolejkowania: %v", err)
+	}
+
+	dispatcher := newWebhookDispatcher(store)
+	// The test server listens on the loopback, which webhooks may not reach.
+	dispatcher.client = server.Client()
+	if sent := dispatcher.deliverDue(context.Background()); sent != 1 {
+		t.Fatalf("Oczekiwano jednej dostawy, otrzymano %d", sent)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	timestamp, _ := strconv.ParseInt(headers.Get("X-KatPoker-Timestamp"), 10, 64)
+	if headers.Get("X-KatPoker-Signature") != signWebhookPayload("sekret", timestamp, body) {
+		t.Errorf("Nieprawidłowy podpis: %s", headers.Get("X-KatPoker-Signature"))
+	}
+	if headers.Get("X-KatPoker-Event") != eventStoryEstimated {
+		t.Errorf("Nieprawidłowy typ zdarzenia: %s", headers.Get("X-KatPoker-Event"))
+	}
+
+	var received Event
+	if err := json.Unmarshal(body, &received); err != nil || received.ID != "e1" || received.SessionID != "session-1" {
+		t.Errorf("Nieprawidłowa treść: %s", body)
+	}
+
+	delivery := store.deliveries[0]
+	if delivery.Status != deliveryDelivered || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("Dostawa nie została oznaczona jako dostarczona: %+v", delivery)
+	}
+}
+
+func TestWebhookDispatcherRetriesWithBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := newMemoryWebhookStore(&WebhookSubscription{ID: "a", URL: server.URL, Secret: "sekret"})
+	if err := enqueueWebhooks(store, Event{ID: "e1", Type: eventRoundStarted, SessionID: "session-1"}); err != nil {
+		t.Fatalf("Błąd kolejkowania: %v", err)
+	}
+
+	now := time.Now().UTC()
+	dispatcher := newWebhookDispatcher(store)
+	dispatcher.client = server.Client()
+	dispatcher.now = func() time.Time { return now }
+
+	dispatcher.deliverDue(context.Background())
+	delivery := store.deliveries[0]
+	if delivery.Status != deliveryPending || len(delivery.Attempts) != 1 {
+		t.Fatalf("Dostawa powinna czekać na ponowienie: %+v", delivery)
+	}
+	if delivery.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Nie zapisano kodu odpowiedzi: %+v", delivery.Attempts[0])
+	}
+	if !delivery.NextAttemptAt.Equal(now.Add(webhookRetryBase)) {
+		t.Errorf("Nieprawidłowy termin ponowienia: %v", delivery.NextAttemptAt)
+	}
+
+	if sent := dispatcher.deliverDue(context.Background()); sent != 0 {
+		t.Errorf("Dostawa została ponowiona przed czasem")
+	}
+
+	for i := 1; i < maxWebhookAttempts; i++ {
+		now = now.Add(webhookRetryMax)
+		dispatcher.deliverDue(context.Background())
+	}
+
+	delivery = store.deliveries[0]
+	if delivery.Status != deliveryFailed || len(delivery.Attempts) != maxWebhookAttempts {
+		t.Errorf("Oczekiwano porażki po %d próbach, otrzymano %s po %d", maxWebhookAttempts, delivery.Status, len(delivery.Attempts))
+	}
+}
+
+func TestValidateWebhookPayload(t *testing.T) {
+	ctx := context.Background()
+	if err := validateWebhookPayload(ctx, "https://93.184.215.14/hook", []string{eventSessionEnded}); err != nil {
+		t.Errorf("Poprawny webhook odrzucony: %v", err)
+	}
+	// Just outside the carrier-grade NAT range.
+	if err := validateWebhookPayload(ctx, "https://100.128.0.1/hook", nil); err != nil {
+		t.Errorf("Publiczny adres odrzucony: %v", err)
+	}
+	if err := validateWebhookPayload(ctx, "ftp://example.com", nil); err == nil {
+		t.Errorf("Oczekiwano błędu dla adresu ftp")
+	}
+	if err := validateWebhookPayload(ctx, "https://example.com", []string{"vote.cast"}); err == nil {
+		t.Errorf("Oczekiwano błędu dla nieznanego zdarzenia")
+	}
+
+	internal := []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://172.16.0.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://0.0.0.0:27017/",
+		"http://0.1.2.3/hook",
+		"http://100.64.0.1/hook",
+		"http://100.127.255.254/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://[fd00::1]/hook",
+	}
+	for _, rawURL := range internal {
+		if err := validateWebhookPayload(ctx, rawURL, nil); err == nil {
+			t.Errorf("Oczekiwano błędu dla adresu wewnętrznego %s", rawURL)
+		}
+	}
+}
+
+func TestWebhookDispatcherRefusesInternalAddresses(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	// Added before the address checks, or pointing at a host that changed
+	// its address since.
+	store := newMemoryWebhookStore(&WebhookSubscription{ID: "a", URL: server.URL, Secret: "sekret"})
+	if err := enqueueWebhooks(store, Event{ID: "e1", Type: eventRoundStarted, SessionID: "session-1"}); err != nil {
+		t.Fatalf("Błąd kolejkowania: %v", err)
+	}
+
+	newWebhookDispatcher(store).deliverDue(context.Background())
+	if requests.Load() != 0 {
+		t.Errorf("Webhook dotarł pod adres wewnętrzny")
+	}
+	attempt := store.deliveries[0].Attempts[0]
+	if attempt.StatusCode != 0 || !strings.Contains(attempt.Error, errWebhookAddress.Error()) {
+		t.Errorf("Nieoczekiwana próba dostawy: %+v", attempt)
+	}
+}
+
+func TestWebhookDispatcherDoesNotFollowRedirects(t *testing.T) {
+	var redirected atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			redirected.Add(1)
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer server.Close()
+
+	store := newMemoryWebhookStore(&WebhookSubscription{ID: "a", URL: server.URL + "/hook", Secret: "sekret"})
+	if err := enqueueWebhooks(store, Event{ID: "e1", Type: eventRoundStarted, SessionID: "session-1"}); err != nil {
+		t.Fatalf("Błąd kolejkowania: %v", err)
+	}
+
+	dispatcher := newWebhookDispatcher(store)
+	client := server.Client()
+	client.CheckRedirect = dispatcher.client.CheckRedirect
+	dispatcher.client = client
+	dispatcher.deliverDue(context.Background())
+
+	if redirected.Load() != 0 {
+		t.Errorf("Przekierowanie webhooka zostało wykonane")
+	}
+	if attempt := store.deliveries[0].Attempts[0]; attempt.StatusCode != http.StatusFound {
+		t.Errorf("Przekierowanie powinno być nieudaną dostawą: %+v", attempt)
+	}
+}
+
+func TestSessionWebhooksRequireLoggedInFacilitator(t *testing.T) {
+	requireMongo(t)
+
+	previous := webhooks
+	webhooks = newMemoryWebhookStore()
+	defer func() { webhooks = previous }()
+
+	session := &Session{Name: "Bez konta"}
+	prepareSession(session, "")
+	if err := saveSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"url": "https://93.184.215.14/hook"}`
+	req := httptest.NewRequest("POST", "/sessions/"+session.ID+"/webhooks", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": session.ID})
+	rr := httptest.NewRecorder()
+	createSessionWebhookHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Oczekiwano %d dla sesji bez prowadzącego, otrzymano %d", http.StatusForbidden, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/sessions/"+session.ID+"/webhooks", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": session.ID})
+	rr = httptest.NewRecorder()
+	listSessionWebhooksHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Oczekiwano %d przy liście webhooków, otrzymano %d", http.StatusForbidden, rr.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsSendBuffer is how many messages may wait for a slow client before it is
+// disconnected.
+const wsSendBuffer = 32
+
+// wsMessage is a message waiting to be written to a client. A closing message
+// is the last one: the connection is closed after it.
+type wsMessage struct {
+	text    string
+	closing bool
+}
+
+// wsClient is one WebSocket connection. Only its writer goroutine writes to
+// the connection, because gorilla/websocket allows a single writer at a time.
+type wsClient struct {
+	conn *websocket.Conn
+	// player is the player who opened the connection, for events meant only
+	// for some of the players.
+	player string
+	send   chan wsMessage
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (c *wsClient) writer() {
+	defer c.conn.Close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			if err := c.conn.WriteMessage(websocket.TextMessage, []byte(message.text)); err != nil {
+				log.Printf("Error sending WebSocket message: %v", err)
+				c.stop()
+				return
+			}
+			if message.closing {
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, message.text)
+				c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+				c.stop()
+				return
+			}
+		}
+	}
+}
+
+// post queues the message without waiting. A client that does not keep up is
+// disconnected.
+func (c *wsClient) post(message wsMessage) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Printf("WebSocket client too slow, disconnecting")
+		c.stop()
+		return false
+	}
+}
+
+func (c *wsClient) stop() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// wsHub keeps the connections of every session. Request handlers and the
+// session sweeper post messages to it instead of writing to connections.
+type wsHub struct {
+	mu       sync.Mutex
+	sessions map[string][]*wsClient
+}
+
+var sessionHub = &wsHub{sessions: make(map[string][]*wsClient)}
+
+func (h *wsHub) add(sessionID string, conn *websocket.Conn, player string) *wsClient {
+	client := &wsClient{
+		conn:   conn,
+		player: player,
+		send:   make(chan wsMessage, wsSendBuffer),
+		done:   make(chan struct{}),
+	}
+	go client.writer()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessions[sessionID] = append(h.sessions[sessionID], client)
+	return client
+}
+
+func (h *wsHub) remove(sessionID string, client *wsClient) {
+	client.stop()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := h.sessions[sessionID]
+	for i, c := range clients {
+		if c == client {
+			h.sessions[sessionID] = append(clients[:i:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(h.sessions[sessionID]) == 0 {
+		delete(h.sessions, sessionID)
+	}
+}
+
+func (h *wsHub) clients(sessionID string) []*wsClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*wsClient{}, h.sessions[sessionID]...)
+}
+
+// post queues the message for the clients of the session accepted by
+// recipient, or for all of them when it is nil, and returns how many got it.
+func (h *wsHub) post(sessionID string, message wsMessage, recipient func(*wsClient) bool) int {
+	sent := 0
+	for _, client := range h.clients(sessionID) {
+		if recipient != nil && !recipient(client) {
+			continue
+		}
+		if client.post(message) {
+			sent++
+		} else {
+			h.remove(sessionID, client)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func dialSession(t *testing.T, server *httptest.Server, sessionID, player string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + sessionID + "?player=" + player
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Błąd połączenia WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitForClients(t *testing.T, sessionID string, count int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sessionHub.clients(sessionID)) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Oczekiwano %d połączeń, jest %d", count, len(sessionHub.clients(sessionID)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readUntilClosed returns the messages received before the server closed the
+// connection.
+func readUntilClosed(t *testing.T, conn *websocket.Conn) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var messages []string
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("Oczekiwano zamknięcia połączenia, otrzymano %v", err)
+			}
+			return messages
+		}
+		messages = append(messages, string(data))
+	}
+}
+
+func TestWebSocketHubConcurrentWritesAndClose(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/{id}", sessionWebSocket)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	sessionID := "session-hub"
+	ala := dialSession(t, server, sessionID, "Ala")
+	jacek := dialSession(t, server, sessionID, "Jacek")
+	waitForClients(t, sessionID, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			notifySessionParticipants(sessionID, "/vote")
+		}()
+		go func() {
+			defer wg.Done()
+			notifyPlayers(sessionID, []string{"Ala"}, "/speak-up")
+		}()
+	}
+	wg.Wait()
+	disconnectSession(sessionID, "/session-closed")
+
+	count := func(messages []string, text string) int {
+		n := 0
+		for _, message := range messages {
+			if message == text {
+				n++
+			}
+		}
+		return n
+	}
+
+	messages := readUntilClosed(t, ala)
+	if count(messages, "/vote") != 10 || count(messages, "/speak-up") != 10 || messages[len(messages)-1] != "/session-closed" {
+		t.Errorf("Nieoczekiwane wiadomości Ali: %v", messages)
+	}
+	messages = readUntilClosed(t, jacek)
+	if count(messages, "/vote") != 10 || count(messages, "/speak-up") != 0 || messages[len(messages)-1] != "/session-closed" {
+		t.Errorf("Nieoczekiwane wiadomości Jacka: %v", messages)
+	}
+
+	waitForClients(t, sessionID, 0)
+}